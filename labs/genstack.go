@@ -0,0 +1,108 @@
+package main
+
+import (
+	"d7024e/kademlia"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+)
+
+// stackTemplate is a docker stack with one bootstrap node that the
+// replicated nodes join through, configured by the env main reads
+var stackTemplate = template.Must(template.New("stack").Parse(`# Generated by: go run . genstack --nodes {{.Nodes}}
+# Deploy with: docker stack deploy -c <this file> kademlia
+version: "3"
+services:
+  bootstrap:
+    image: {{.Image}}
+    stdin_open: true
+    tty: true
+    environment:
+      LISTEN_PORT: "{{.Port}}"
+      K: "{{.K}}"
+      ALPHA: "{{.Alpha}}"
+    deploy:
+      restart_policy:
+        condition: on-failure
+        delay: 5s
+    networks:
+      - kademlia_network
+{{- if gt .Nodes 1}}
+
+  kademliaNodes:
+    image: {{.Image}}
+    stdin_open: true
+    tty: true
+    environment:
+      LISTEN_PORT: "{{.Port}}"
+      BOOTSTRAP: "bootstrap:{{.Port}}"
+      K: "{{.K}}"
+      ALPHA: "{{.Alpha}}"
+    depends_on:
+      - bootstrap
+    deploy:
+      mode: replicated
+      replicas: {{.Replicas}}
+      restart_policy:
+        condition: on-failure
+        delay: 5s
+        max_attempts: 3
+        window: 10s
+    networks:
+      - kademlia_network
+{{- end}}
+
+networks:
+  kademlia_network:
+`))
+
+// stack is what stackTemplate is filled in with
+type stack struct {
+	Nodes    int
+	Replicas int
+	Image    string
+	Port     int
+	K        int
+	Alpha    int
+}
+
+// genstack writes a docker stack file for a network of --nodes nodes
+func genstack(args []string) error {
+	config := kademlia.DefaultConfig()
+	flags := flag.NewFlagSet("genstack", flag.ExitOnError)
+	nodes := flags.Int("nodes", 50, "number of nodes, including the bootstrap node")
+	image := flags.String("image", "kadlab:latest", "docker image of a node")
+	port := flags.Int("port", 8000, "UDP port every node listens on")
+	output := flags.String("o", "", "file to write, stdout if empty")
+	flags.IntVar(&config.K, "k", config.K, "bucket size and number of contacts returned by a lookup")
+	flags.IntVar(&config.Alpha, "alpha", config.Alpha, "number of concurrent queries of a lookup")
+	flags.Parse(args)
+
+	if *nodes < 1 {
+		return fmt.Errorf("at least 1 node is needed, got %d", *nodes)
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %v", *output, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	return stackTemplate.Execute(out, stack{
+		Nodes:    *nodes,
+		Replicas: *nodes - 1,
+		Image:    *image,
+		Port:     *port,
+		K:        config.K,
+		Alpha:    config.Alpha,
+	})
+}
@@ -0,0 +1,156 @@
+package kademlia
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxStrikes is how many peers a BanList counts strikes for at most,
+// since keys like addresses can be spoofed to fill the map
+const maxStrikes = 4096
+
+// BanList definition
+// keeps the peers that misbehaved, such as sending malformed packets or
+// failing signature checks, out of the routing table and lookups for a
+// while. Peers are keyed by hex encoded KademliaID or by address, since
+// a malformed packet may not carry a usable ID. Strikes are forgotten
+// once they are older than the ban duration
+type BanList struct {
+	mu        sync.Mutex
+	duration  time.Duration
+	threshold int
+	strikes   map[string]strikes
+	banned    map[string]time.Time // until when
+	clock     Clock
+}
+
+// strikes counts the misbehaviour of a peer since its first strike
+type strikes struct {
+	count int
+	since time.Time
+}
+
+// Ban is an entry of a BanList
+type Ban struct {
+	Key   string    `json:"key"`
+	Until time.Time `json:"until"`
+}
+
+// NewBanList returns a new instance of a BanList banning a peer for
+// duration once it has misbehaved threshold times
+func NewBanList(duration time.Duration, threshold int) *BanList {
+	return &BanList{
+		duration:  duration,
+		threshold: threshold,
+		strikes:   make(map[string]strikes),
+		banned:    make(map[string]time.Time),
+		clock:     realClock{},
+	}
+}
+
+// SetClock makes the BanList time bans by clock
+func (bans *BanList) SetClock(clock Clock) {
+	bans.mu.Lock()
+	defer bans.mu.Unlock()
+	bans.clock = clock
+}
+
+// Strike records that the peer with key misbehaved, returning true
+// if that got it banned
+func (bans *BanList) Strike(key string) bool {
+	bans.mu.Lock()
+	defer bans.mu.Unlock()
+	now := bans.clock.Now()
+	counted, exists := bans.strikes[key]
+	if !exists || !now.Before(counted.since.Add(bans.duration)) {
+		if !exists && len(bans.strikes) >= maxStrikes {
+			bans.forgetStrikes(now)
+		}
+		counted = strikes{since: now}
+	}
+	if counted.count++; counted.count < bans.threshold {
+		bans.strikes[key] = counted
+		return false
+	}
+	delete(bans.strikes, key)
+	bans.banned[key] = now.Add(bans.duration)
+	return true
+}
+
+// Add bans the peer with key for duration, regardless of strikes
+func (bans *BanList) Add(key string, duration time.Duration) {
+	bans.mu.Lock()
+	defer bans.mu.Unlock()
+	bans.banned[key] = bans.clock.Now().Add(duration)
+}
+
+// Remove lifts the ban of the peer with key and forgets its strikes
+func (bans *BanList) Remove(key string) {
+	bans.mu.Lock()
+	defer bans.mu.Unlock()
+	delete(bans.banned, key)
+	delete(bans.strikes, key)
+}
+
+// IsBanned returns true if the ID or the address of contact is banned.
+// It is safe to call on a nil *BanList, which bans nobody
+func (bans *BanList) IsBanned(contact Contact) bool {
+	if bans == nil {
+		return false
+	}
+	bans.mu.Lock()
+	defer bans.mu.Unlock()
+	now := bans.clock.Now()
+	return bans.isBanned(contact.ID.String(), now) || bans.isBanned(contact.Address, now)
+}
+
+// List returns the current bans ordered by key
+func (bans *BanList) List() []Ban {
+	bans.mu.Lock()
+	defer bans.mu.Unlock()
+	now := bans.clock.Now()
+	var list []Ban
+	for key, until := range bans.banned {
+		if bans.isBanned(key, now) {
+			list = append(list, Ban{key, until})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// isBanned returns true if key is banned at now, dropping the ban if it
+// has run out, the caller must hold the lock
+func (bans *BanList) isBanned(key string, now time.Time) bool {
+	until, exists := bans.banned[key]
+	if exists && !now.Before(until) {
+		delete(bans.banned, key)
+		return false
+	}
+	return exists
+}
+
+// forgetStrikes drops the strikes older than the ban duration, and the
+// older half of the rest if that left no room, so a flood of spoofed
+// keys costs one sort every maxStrikes/2 strikes. The caller must hold
+// the lock
+func (bans *BanList) forgetStrikes(now time.Time) {
+	var keys []string
+	for key, counted := range bans.strikes {
+		if !now.Before(counted.since.Add(bans.duration)) {
+			delete(bans.strikes, key)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) < maxStrikes {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bans.strikes[keys[i]].since.Before(bans.strikes[keys[j]].since)
+	})
+	for _, key := range keys[:len(keys)/2] {
+		delete(bans.strikes, key)
+	}
+}
@@ -0,0 +1,87 @@
+package kademlia
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	bans := NewBanList(time.Hour, 2)
+	rt := NewRoutingTable(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"))
+	rt.SetBanList(bans)
+
+	liar := NewContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001")
+	spammer := NewContact(NewKademliaID("2111111400000000000000000000000000000000"), "localhost:8002")
+	rt.AddContact(liar)
+	rt.AddContact(spammer)
+
+	// Two failed signature checks ban the ID, malformed packets the address
+	if bans.Strike(liar.ID.String()) {
+		t.Fatal("Expected one strike not to ban")
+	}
+	if !bans.Strike(liar.ID.String()) {
+		t.Fatal("Expected the second strike to ban")
+	}
+	bans.Add(spammer.Address, 10*time.Millisecond)
+
+	if contacts := rt.FindClosestContacts(liar.ID, 20); len(contacts) != 0 {
+		t.Fatalf("Expected banned contacts to be left out but got %v", contacts)
+	}
+	if list := bans.List(); len(list) != 2 {
+		t.Fatalf("Expected 2 bans but got %v", list)
+	}
+
+	// Bans run out, or are lifted
+	time.Sleep(20 * time.Millisecond)
+	bans.Remove(liar.ID.String())
+	if contacts := rt.FindClosestContacts(liar.ID, 20); len(contacts) != 2 {
+		t.Fatalf("Expected both contacts back but got %v", contacts)
+	}
+
+	bans.Add(liar.ID.String(), time.Hour)
+	if list := bans.List(); len(list) != 1 || list[0].Key != liar.ID.String() {
+		t.Fatalf("Expected only the liar to be banned but got %v", list)
+	}
+}
+
+func TestBanListDropsBannedContacts(t *testing.T) {
+	bans := NewBanList(time.Hour, 1)
+	rt := NewRoutingTableWithConfig(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"), Config{K: 2, Alpha: 1})
+	rt.SetBanList(bans)
+
+	liar := NewContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001")
+	honest := NewContact(NewKademliaID("2111111400000000000000000000000000000000"), "localhost:8002")
+	rt.AddContact(liar)
+	rt.AddContact(honest)
+	bans.Strike(liar.ID.String())
+
+	// the bucket is full, but the banned contact gives up its slot
+	newcomer := NewContact(NewKademliaID("3111111400000000000000000000000000000000"), "localhost:8003")
+	rt.AddContact(newcomer)
+	if _, ok := rt.GetContact(liar.ID); ok {
+		t.Fatal("Expected the banned contact to be dropped from its bucket")
+	}
+	if _, ok := rt.GetContact(newcomer.ID); !ok {
+		t.Fatal("Expected the new contact to take the banned one's slot")
+	}
+}
+
+func TestBanListForgetsStrikes(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	bans := NewBanList(time.Minute, 2)
+	bans.SetClock(clock)
+
+	bans.Strike("10.0.0.1:8000")
+	clock.Advance(time.Minute)
+	if bans.Strike("10.0.0.1:8000") {
+		t.Fatal("Expected a strike older than the ban duration to be forgotten")
+	}
+
+	for i := 0; i < 2*maxStrikes; i++ {
+		bans.Strike(fmt.Sprintf("10.0.%d.%d:8000", i/256, i%256))
+	}
+	if len(bans.strikes) > maxStrikes {
+		t.Fatalf("Expected at most %d peers with strikes but got %d", maxStrikes, len(bans.strikes))
+	}
+}
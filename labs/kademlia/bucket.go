@@ -2,24 +2,44 @@ package kademlia
 
 import (
 	"container/list"
+	"time"
 )
 
 // bucket definition
-// contains a List
+// contains a List, and a replacement cache of the most recently
+// seen contacts that didn't fit while the bucket was full
 type bucket struct {
-	list *list.List
+	size         int
+	list         *list.List
+	replacements *list.List
+	events       *Events
+	clock        Clock
 }
 
-// newBucket returns a new instance of a bucket
-func newBucket() *bucket {
-	bucket := &bucket{}
+// newBucket returns a new instance of a bucket holding size contacts
+func newBucket(size int) *bucket {
+	bucket := &bucket{size: size, clock: realClock{}}
 	bucket.list = list.New()
+	bucket.replacements = list.New()
 	return bucket
 }
 
 // AddContact adds the Contact to the front of the bucket
-// or moves it to the front of the bucket if it already existed
+// or moves it to the front of the bucket if it already existed.
+// A verified Contact replaces an unverified entry for the same node,
+// an unverified Contact never downgrades a verified entry.
+// The address of an existing entry is kept, see UpdateAddress.
+// Adding a verified Contact marks it as seen now and clears its failures
 func (bucket *bucket) AddContact(contact Contact) {
+	now := bucket.clock.Now()
+	bucket.removeExpired(now)
+	if contact.IsVerified() {
+		contact.lastSeen = now
+		contact.failures = 0
+	} else if contact.learnedAt.IsZero() {
+		contact.learnedAt = now
+	}
+
 	var element *list.Element
 	for e := bucket.list.Front(); e != nil; e = e.Next() {
 		nodeID := e.Value.(Contact).ID
@@ -30,19 +50,209 @@ func (bucket *bucket) AddContact(contact Contact) {
 	}
 
 	if element == nil {
-		if bucket.list.Len() < bucketSize {
+		if bucket.list.Len() < bucket.size {
 			bucket.list.PushFront(contact)
+			bucket.events.emitContactAdded(contact)
+		} else {
+			bucket.addReplacement(contact)
 		}
 	} else {
+		if contact.IsVerified() {
+			contact.Address = element.Value.(Contact).Address
+			contact.rtt = element.Value.(Contact).rtt
+			element.Value = contact
+		}
 		bucket.list.MoveToFront(element)
 	}
 }
 
+// GetContact returns the Contact with id, ok is false if it isn't in the bucket
+func (bucket *bucket) GetContact(id *KademliaID) (contact Contact, ok bool) {
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		if contact := e.Value.(Contact); contact.ID.Equals(id) {
+			return contact, true
+		}
+	}
+	return Contact{}, false
+}
+
+// UpdateAddress sets the address of the Contact with id,
+// returning false if it isn't in the bucket
+func (bucket *bucket) UpdateAddress(id *KademliaID, address string) bool {
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		if contact := e.Value.(Contact); contact.ID.Equals(id) {
+			contact.Address = address
+			e.Value = contact
+			return true
+		}
+	}
+	return false
+}
+
+// RecordRTT folds a measured round-trip time into the smoothed RTT of the
+// Contact with id like TCP does, returning false if it isn't in the bucket
+func (bucket *bucket) RecordRTT(id *KademliaID, rtt time.Duration) bool {
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		if contact := e.Value.(Contact); contact.ID.Equals(id) {
+			if contact.rtt == 0 {
+				contact.rtt = rtt
+			} else {
+				contact.rtt = (7*contact.rtt + rtt) / 8
+			}
+			e.Value = contact
+			return true
+		}
+	}
+	return false
+}
+
+// RecordFailure counts a failed RPC to the Contact with id and returns
+// how many RPCs to it have failed in a row, 0 if it isn't in the bucket
+func (bucket *bucket) RecordFailure(id *KademliaID) int {
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		if contact := e.Value.(Contact); contact.ID.Equals(id) {
+			contact.failures++
+			e.Value = contact
+			return contact.failures
+		}
+	}
+	return 0
+}
+
+// RemoveContact removes the Contact with id from the bucket,
+// returning false if it isn't in the bucket
+func (bucket *bucket) RemoveContact(id *KademliaID) bool {
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		if contact := e.Value.(Contact); contact.ID.Equals(id) {
+			bucket.list.Remove(e)
+			bucket.events.emitContactEvicted(contact)
+			bucket.promoteReplacement()
+			return true
+		}
+	}
+	return false
+}
+
+// Replacements returns the contacts in the replacement cache,
+// most recently seen first
+func (bucket *bucket) Replacements() []Contact {
+	var contacts []Contact
+	for e := bucket.replacements.Front(); e != nil; e = e.Next() {
+		contacts = append(contacts, e.Value.(Contact))
+	}
+	return contacts
+}
+
+// addReplacement puts the contact at the front of the replacement
+// cache, dropping the least recently seen one if it holds
+// more than the bucket size
+func (bucket *bucket) addReplacement(contact Contact) {
+	for e := bucket.replacements.Front(); e != nil; e = e.Next() {
+		if e.Value.(Contact).ID.Equals(contact.ID) {
+			bucket.replacements.Remove(e)
+			break
+		}
+	}
+	bucket.replacements.PushFront(contact)
+	if bucket.replacements.Len() > bucket.size {
+		bucket.replacements.Remove(bucket.replacements.Back())
+	}
+}
+
+// promoteReplacement moves the most recently seen contact from the
+// replacement cache into the bucket. It goes to the back, as the
+// least recently seen entry, since it hasn't been heard from since
+func (bucket *bucket) promoteReplacement() {
+	now := bucket.clock.Now()
+	for bucket.list.Len() < bucket.size && bucket.replacements.Len() > 0 {
+		contact := bucket.replacements.Remove(bucket.replacements.Front()).(Contact)
+		if !contact.expired(now, unverifiedContactTTL) {
+			bucket.list.PushBack(contact)
+			bucket.events.emitContactAdded(contact)
+		}
+	}
+}
+
+// staleContacts returns the verified Contacts that have not been
+// seen for longer than age
+func (bucket *bucket) staleContacts(now time.Time, age time.Duration) []Contact {
+	var contacts []Contact
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		if contact := e.Value.(Contact); contact.stale(now, age) {
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts
+}
+
+// split moves the contacts for which move returns true, including
+// those in the replacement cache, to a new bucket and returns it
+func (bucket *bucket) split(move func(Contact) bool) *bucket {
+	other := newBucket(bucket.size)
+	other.events = bucket.events
+	other.clock = bucket.clock
+	for _, lists := range [][2]*list.List{{bucket.list, other.list}, {bucket.replacements, other.replacements}} {
+		from, to := lists[0], lists[1]
+		for e := from.Front(); e != nil; {
+			next := e.Next()
+			if move(e.Value.(Contact)) {
+				to.PushBack(from.Remove(e))
+			}
+			e = next
+		}
+	}
+	bucket.promoteReplacement()
+	other.promoteReplacement()
+	return other
+}
+
+// removeExpired drops the unverified Contacts that have not been
+// verified within unverifiedContactTTL
+func (bucket *bucket) removeExpired(now time.Time) {
+	for e := bucket.list.Front(); e != nil; {
+		next := e.Next()
+		contact := e.Value.(Contact)
+		if contact.expired(now, unverifiedContactTTL) {
+			bucket.list.Remove(e)
+			bucket.events.emitContactEvicted(contact)
+		}
+		e = next
+	}
+	bucket.promoteReplacement()
+}
+
+// removeBanned drops the Contacts banned by bans, from the
+// bucket and from the replacement cache
+func (bucket *bucket) removeBanned(bans *BanList) {
+	if bans == nil {
+		return
+	}
+	for e := bucket.replacements.Front(); e != nil; {
+		next := e.Next()
+		if bans.IsBanned(e.Value.(Contact)) {
+			bucket.replacements.Remove(e)
+		}
+		e = next
+	}
+	for e := bucket.list.Front(); e != nil; {
+		next := e.Next()
+		contact := e.Value.(Contact)
+		if bans.IsBanned(contact) {
+			bucket.list.Remove(e)
+			bucket.events.emitContactEvicted(contact)
+		}
+		e = next
+	}
+	bucket.promoteReplacement()
+}
+
 // GetContactAndCalcDistance returns an array of Contacts where 
 // the distance has already been calculated
 func (bucket *bucket) GetContactAndCalcDistance(target *KademliaID) []Contact {
 	var contacts []Contact
 
+	bucket.removeExpired(bucket.clock.Now())
+
 	for elt := bucket.list.Front(); elt != nil; elt = elt.Next() {
 		contact := elt.Value.(Contact)
 		contact.CalcDistance(target)
@@ -0,0 +1,65 @@
+package kademlia
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketExpiresUnverifiedContacts(t *testing.T) {
+	bucket := newBucket(DefaultConfig().K)
+
+	stale := NewUnverifiedContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001")
+	stale.learnedAt = time.Now().Add(-2 * unverifiedContactTTL)
+	fresh := NewUnverifiedContact(NewKademliaID("2222222200000000000000000000000000000000"), "localhost:8002")
+	bucket.AddContact(stale)
+	bucket.AddContact(fresh)
+
+	contacts := bucket.GetContactAndCalcDistance(NewKademliaID("0000000000000000000000000000000000000000"))
+	if len(contacts) != 1 || !contacts[0].ID.Equals(fresh.ID) {
+		t.Fatalf("Expected only the fresh unverified contact but got %v", contacts)
+	}
+}
+
+func TestBucketVerifiesContacts(t *testing.T) {
+	bucket := newBucket(DefaultConfig().K)
+	id := NewKademliaID("1111111100000000000000000000000000000000")
+
+	bucket.AddContact(NewUnverifiedContact(id, "localhost:8001"))
+	bucket.AddContact(NewContact(id, "localhost:8001"))
+	bucket.AddContact(NewUnverifiedContact(id, "localhost:8001"))
+
+	if bucket.Len() != 1 {
+		t.Fatalf("Expected 1 contact but got %d", bucket.Len())
+	}
+	contact := bucket.list.Front().Value.(Contact)
+	if !contact.IsVerified() {
+		t.Fatalf("Expected %s to be verified", contact.String())
+	}
+}
+
+func TestBucketPromotesReplacements(t *testing.T) {
+	bucket := newBucket(DefaultConfig().K)
+	for i := 0; i < bucket.size; i++ {
+		bucket.AddContact(NewContact(NewRandomKademliaID(), "localhost:8000"))
+	}
+	evicted := bucket.list.Back().Value.(Contact)
+
+	older := NewContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001")
+	newer := NewContact(NewKademliaID("2222222200000000000000000000000000000000"), "localhost:8002")
+	bucket.AddContact(older)
+	bucket.AddContact(newer)
+	if bucket.Len() != bucket.size || len(bucket.Replacements()) != 2 {
+		t.Fatalf("Expected a full bucket and 2 replacements but got %d and %d", bucket.Len(), len(bucket.Replacements()))
+	}
+
+	bucket.RemoveContact(evicted.ID)
+	if _, ok := bucket.GetContact(newer.ID); !ok {
+		t.Fatal("Expected the most recently seen replacement to be promoted")
+	}
+	if _, ok := bucket.GetContact(older.ID); ok {
+		t.Fatal("Expected only one replacement to be promoted")
+	}
+	if replacements := bucket.Replacements(); len(replacements) != 1 || !replacements[0].ID.Equals(older.ID) {
+		t.Fatalf("Expected the older replacement to stay cached but got %v", replacements)
+	}
+}
@@ -0,0 +1,76 @@
+package kademlia
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock definition
+// the source of time for expirations and timers, so tests and
+// simulations can replace hours of waiting with a FakeClock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the Clock of the operating system, the default
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// FakeClock definition
+// a Clock that only moves when Advance is called
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+// fakeWaiter is a pending After of a FakeClock
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewFakeClock returns a new instance of a FakeClock set to start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the current fake time
+func (clock *FakeClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+// After returns a channel that receives the fake time
+// once the clock has been advanced by d
+func (clock *FakeClock) After(d time.Duration) <-chan time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- clock.now
+		return ch
+	}
+	clock.waiters = append(clock.waiters, fakeWaiter{clock.now.Add(d), ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing every After that is due
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.now = clock.now.Add(d)
+	pending := clock.waiters[:0]
+	for _, waiter := range clock.waiters {
+		if waiter.at.After(clock.now) {
+			pending = append(pending, waiter)
+			continue
+		}
+		waiter.ch <- clock.now
+	}
+	clock.waiters = pending
+}
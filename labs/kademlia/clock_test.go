@@ -0,0 +1,41 @@
+package kademlia
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockDrivesExpiryAndSweeper(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	rt := NewRoutingTable(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"))
+	rt.SetClock(clock)
+
+	learned := NewUnverifiedContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001")
+	dead := NewContact(NewKademliaID("2111111400000000000000000000000000000000"), "localhost:8002")
+	rt.AddContact(learned)
+	rt.AddContact(dead)
+
+	clock.Advance(2 * unverifiedContactTTL)
+	if contacts := rt.FindClosestContacts(learned.ID, 20); len(contacts) != 1 {
+		t.Fatalf("Expected the unverified contact to have expired but got %v", contacts)
+	}
+
+	// A day of sweeping every hour, in milliseconds
+	pings := 0
+	stop := rt.StartSweeper(time.Hour, func(contact Contact) bool {
+		pings++
+		return false
+	})
+	defer stop()
+
+	for hour := 0; hour < 24; hour++ {
+		if _, ok := rt.GetContact(dead.ID); !ok {
+			break
+		}
+		clock.Advance(time.Hour)
+		time.Sleep(5 * time.Millisecond) // let the sweeper run
+	}
+	if _, ok := rt.GetContact(dead.ID); ok {
+		t.Fatal("Expected the dead contact to be dropped by the sweeper within a day")
+	}
+}
@@ -0,0 +1,27 @@
+package kademlia
+
+import "fmt"
+
+// Config definition
+// the protocol parameters of a node, so experiments can compare
+// them without recompiling
+type Config struct {
+	K     int // bucket size and number of contacts a lookup returns
+	Alpha int // number of concurrent queries of a lookup
+}
+
+// DefaultConfig returns the parameters suggested in the Kademlia paper
+func DefaultConfig() Config {
+	return Config{K: 20, Alpha: 3}
+}
+
+// Validate returns an error if a parameter is out of range
+func (config Config) Validate() error {
+	if config.K < 1 {
+		return fmt.Errorf("k must be at least 1, got %d", config.K)
+	}
+	if config.Alpha < 1 {
+		return fmt.Errorf("alpha must be at least 1, got %d", config.Alpha)
+	}
+	return nil
+}
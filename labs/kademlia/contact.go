@@ -2,7 +2,9 @@ package kademlia
 
 import (
 	"fmt"
-	"sort"
+	"net"
+	"strconv"
+	"time"
 )
 
 // Contact definition
@@ -11,63 +13,86 @@ type Contact struct {
 	ID       *KademliaID
 	Address  string
 	distance *KademliaID
+
+	// unverified is set for contacts only learned from another node's
+	// FIND_NODE response, learnedAt is when that happened
+	unverified bool
+	learnedAt  time.Time
+
+	// lastSeen is when the node last answered or contacted us, failures
+	// is the number of RPCs to it that have failed in a row since then
+	lastSeen time.Time
+	failures int
+
+	// rtt is the smoothed round-trip time of RPCs to the node,
+	// 0 until one has been measured
+	rtt time.Duration
 }
 
 // NewContact returns a new instance of a Contact
+// for a node that has been heard from directly
 func NewContact(id *KademliaID, address string) Contact {
-	return Contact{id, address, nil}
+	return Contact{ID: id, Address: address}
 }
 
-// CalcDistance calculates the distance to the target and 
-// fills the contacts distance field
-func (contact *Contact) CalcDistance(target *KademliaID) {
-	contact.distance = contact.ID.CalcDistance(target)
+// NewUnverifiedContact returns a new instance of a Contact for a node
+// that was learned from a third party and never heard from directly.
+// The bucket it is added to records when it was learned
+func NewUnverifiedContact(id *KademliaID, address string) Contact {
+	return Contact{ID: id, Address: address, unverified: true}
 }
 
-// Less returns true if contact.distance < otherContact.distance
-func (contact *Contact) Less(otherContact *Contact) bool {
-	return contact.distance.Less(otherContact.distance)
+// RTT returns the smoothed round-trip time to the node,
+// 0 if it hasn't been measured
+func (contact *Contact) RTT() time.Duration {
+	return contact.rtt
 }
 
-// String returns a simple string representation of a Contact
-func (contact *Contact) String() string {
-	return fmt.Sprintf(`contact("%s", "%s")`, contact.ID, contact.Address)
+// IsVerified returns true if the contact has been heard from directly
+func (contact *Contact) IsVerified() bool {
+	return !contact.unverified
 }
 
-// ContactCandidates definition
-// stores an array of Contacts
-type ContactCandidates struct {
-	contacts []Contact
+// expired returns true if the contact is unverified and was learned
+// longer than ttl ago
+func (contact *Contact) expired(now time.Time, ttl time.Duration) bool {
+	return contact.unverified && now.Sub(contact.learnedAt) > ttl
 }
 
-// Append an array of Contacts to the ContactCandidates
-func (candidates *ContactCandidates) Append(contacts []Contact) {
-	candidates.contacts = append(candidates.contacts, contacts...)
+// stale returns true if the contact is verified but has not been
+// seen for longer than age
+func (contact *Contact) stale(now time.Time, age time.Duration) bool {
+	return !contact.unverified && now.Sub(contact.lastSeen) > age
 }
 
-// GetContacts returns the first count number of Contacts
-func (candidates *ContactCandidates) GetContacts(count int) []Contact {
-	return candidates.contacts[:count]
+// ValidAddress returns an error unless address is a host:port pair,
+// where an IPv6 literal host must be in brackets like [::1]:8000
+func ValidAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", address, err)
+	}
+	if host == "" {
+		return fmt.Errorf("invalid address %q: missing host", address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid address %q: bad port %q", address, port)
+	}
+	return nil
 }
 
-// Sort the Contacts in ContactCandidates
-func (candidates *ContactCandidates) Sort() {
-	sort.Sort(candidates)
-}
-
-// Len returns the length of the ContactCandidates
-func (candidates *ContactCandidates) Len() int {
-	return len(candidates.contacts)
+// CalcDistance calculates the distance to the target and 
+// fills the contacts distance field
+func (contact *Contact) CalcDistance(target *KademliaID) {
+	contact.distance = contact.ID.CalcDistance(target)
 }
 
-// Swap the position of the Contacts at i and j
-// WARNING does not check if either i or j is within range
-func (candidates *ContactCandidates) Swap(i, j int) {
-	candidates.contacts[i], candidates.contacts[j] = candidates.contacts[j], candidates.contacts[i]
+// Less returns true if contact.distance < otherContact.distance
+func (contact *Contact) Less(otherContact *Contact) bool {
+	return contact.distance.Less(otherContact.distance)
 }
 
-// Less returns true if the Contact at index i is smaller than 
-// the Contact at index j
-func (candidates *ContactCandidates) Less(i, j int) bool {
-	return candidates.contacts[i].Less(&candidates.contacts[j])
+// String returns a simple string representation of a Contact
+func (contact *Contact) String() string {
+	return fmt.Sprintf(`contact("%s", "%s")`, contact.ID, contact.Address)
 }
@@ -0,0 +1,16 @@
+package kademlia
+
+import "testing"
+
+func TestValidAddress(t *testing.T) {
+	for _, address := range []string{"10.0.0.1:8000", "[::1]:8000", "[fe80::1%eth0]:8000", "kademlia-bootstrap:8000"} {
+		if err := ValidAddress(address); err != nil {
+			t.Errorf("Expected %s to be valid but got %v", address, err)
+		}
+	}
+	for _, address := range []string{"::1:8000", "10.0.0.1", "10.0.0.1:0", "10.0.0.1:http", ":8000"} {
+		if err := ValidAddress(address); err == nil {
+			t.Errorf("Expected %s to be invalid", address)
+		}
+	}
+}
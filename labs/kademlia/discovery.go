@@ -0,0 +1,147 @@
+package kademlia
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiscoveryPort is the default UDP port local discovery runs on
+const DiscoveryPort = 7946
+
+// discoveryMagic prefixes every discovery datagram so unrelated
+// broadcast traffic on the same port is ignored
+const discoveryMagic = "d7024e"
+
+// maxDiscoveredPeers caps the peers a LocalDiscovery keeps, anyone on
+// the segment can announce any number of IDs
+const maxDiscoveredPeers = 64
+
+// LocalDiscovery definition
+// finds other nodes on the same network segment through UDP broadcast,
+// the peers it finds are candidates for bootstrapping
+type LocalDiscovery struct {
+	me     Contact
+	conn   *net.UDPConn
+	target *net.UDPAddr
+	mu     sync.Mutex
+	peers  map[string]Contact
+	order  []string // ids in peers, oldest first
+}
+
+// NewLocalDiscovery returns a new instance of a LocalDiscovery
+// announcing me and listening for other nodes on port
+func NewLocalDiscovery(me Contact, port int) (*LocalDiscovery, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for local discovery: %v", err)
+	}
+
+	discovery := &LocalDiscovery{
+		me:     me,
+		conn:   conn,
+		target: &net.UDPAddr{IP: net.IPv4bcast, Port: port},
+		peers:  make(map[string]Contact),
+	}
+	go discovery.serve()
+	return discovery, nil
+}
+
+// Probe broadcasts a request for other nodes to announce themselves and
+// returns all peers found once timeout has passed
+func (discovery *LocalDiscovery) Probe(timeout time.Duration) ([]Contact, error) {
+	if _, err := discovery.conn.WriteToUDP(discovery.encode("probe"), discovery.target); err != nil {
+		return nil, fmt.Errorf("failed to broadcast probe: %v", err)
+	}
+	time.Sleep(timeout)
+	return discovery.Peers(), nil
+}
+
+// Peers returns the peers found so far, as unverified Contacts
+// since they have not yet answered an RPC
+func (discovery *LocalDiscovery) Peers() []Contact {
+	discovery.mu.Lock()
+	defer discovery.mu.Unlock()
+
+	contacts := make([]Contact, 0, len(discovery.peers))
+	for _, contact := range discovery.peers {
+		contacts = append(contacts, contact)
+	}
+	return contacts
+}
+
+// Close stops listening for other nodes
+func (discovery *LocalDiscovery) Close() error {
+	return discovery.conn.Close()
+}
+
+// serve answers probes and records every node heard from. Its address
+// is taken from the datagram, since the one announced may be of another
+// network or family, only the port comes from the announcement
+func (discovery *LocalDiscovery) serve() {
+	buffer := make([]byte, 256)
+	for {
+		n, from, err := discovery.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		kind, contact, ok := decodeDiscovery(buffer[:n])
+		if !ok || contact.ID.Equals(discovery.me.ID) {
+			continue
+		}
+
+		_, port, _ := net.SplitHostPort(contact.Address)
+		contact.Address = net.JoinHostPort(from.IP.String(), port)
+		discovery.remember(contact)
+
+		if kind == "probe" {
+			discovery.conn.WriteToUDP(discovery.encode("announce"), from)
+		}
+	}
+}
+
+// remember records contact as a peer, evicting the oldest peer
+// once maxDiscoveredPeers are known
+func (discovery *LocalDiscovery) remember(contact Contact) {
+	discovery.mu.Lock()
+	defer discovery.mu.Unlock()
+
+	id := contact.ID.String()
+	if _, known := discovery.peers[id]; !known {
+		if len(discovery.order) >= maxDiscoveredPeers {
+			delete(discovery.peers, discovery.order[0])
+			discovery.order = discovery.order[1:]
+		}
+		discovery.order = append(discovery.order, id)
+	}
+	discovery.peers[id] = contact
+}
+
+// encode returns a discovery datagram of the given kind carrying me
+func (discovery *LocalDiscovery) encode(kind string) []byte {
+	return []byte(fmt.Sprintf("%s %s %s %s", discoveryMagic, kind, discovery.me.ID.String(), discovery.me.Address))
+}
+
+// decodeDiscovery parses a discovery datagram, ok is false
+// if it isn't one
+func decodeDiscovery(data []byte) (kind string, contact Contact, ok bool) {
+	fields := strings.Fields(string(data))
+	if len(fields) != 4 || fields[0] != discoveryMagic {
+		return "", Contact{}, false
+	}
+	if fields[1] != "probe" && fields[1] != "announce" {
+		return "", Contact{}, false
+	}
+	decoded, err := hex.DecodeString(fields[2])
+	if err != nil || len(decoded) != IDLength {
+		return "", Contact{}, false
+	}
+	if ValidAddress(fields[3]) != nil {
+		return "", Contact{}, false
+	}
+	return fields[1], NewUnverifiedContact(NewKademliaID(fields[2]), fields[3]), true
+}
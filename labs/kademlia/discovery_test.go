@@ -0,0 +1,54 @@
+package kademlia
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLocalDiscovery(t *testing.T) {
+	alice, err := NewLocalDiscovery(NewContact(NewRandomKademliaID(), "10.0.0.1:8000"), 0)
+	if err != nil {
+		t.Fatalf("Failed to start discovery: %v", err)
+	}
+	defer alice.Close()
+	bob, err := NewLocalDiscovery(NewContact(NewRandomKademliaID(), "10.0.0.2:8000"), 0)
+	if err != nil {
+		t.Fatalf("Failed to start discovery: %v", err)
+	}
+	defer bob.Close()
+
+	// Broadcast isn't available everywhere, so probe bob directly
+	alice.target = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: bob.conn.LocalAddr().(*net.UDPAddr).Port}
+
+	peers, err := alice.Probe(200 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to probe: %v", err)
+	}
+	if len(peers) != 1 || !peers[0].ID.Equals(bob.me.ID) || peers[0].IsVerified() {
+		t.Fatalf("Expected bob as an unverified peer but got %v", peers)
+	}
+	// alice announced 10.0.0.1, but her probe came from the loopback
+	if learned := bob.Peers(); len(learned) != 1 || learned[0].Address != "127.0.0.1:8000" {
+		t.Fatalf("Expected bob to learn about alice at the probe's source but got %v", learned)
+	}
+}
+
+func TestLocalDiscoveryBoundsPeers(t *testing.T) {
+	discovery := &LocalDiscovery{peers: make(map[string]Contact)}
+	first := NewUnverifiedContact(NewRandomKademliaID(), "10.0.0.1:8000")
+	discovery.remember(first)
+	for i := 0; i < maxDiscoveredPeers; i++ {
+		discovery.remember(NewUnverifiedContact(NewRandomKademliaID(), "10.0.0.2:8000"))
+	}
+
+	peers := discovery.Peers()
+	if len(peers) != maxDiscoveredPeers {
+		t.Fatalf("Expected %d peers but got %d", maxDiscoveredPeers, len(peers))
+	}
+	for _, peer := range peers {
+		if peer.ID.Equals(first.ID) {
+			t.Fatal("Expected the oldest peer to be evicted")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package kademlia
+
+import "sync"
+
+// Events definition
+// lets dashboards and tests subscribe to what happens inside a node.
+// Handlers run synchronously on the goroutine causing the event, so
+// they must be quick and must not call back into the RoutingTable.
+// Emitting on a nil *Events does nothing
+type Events struct {
+	mu             sync.RWMutex
+	contactAdded   []func(Contact)
+	contactEvicted []func(Contact)
+	stored         []func(hash string, data []byte)
+}
+
+// NewEvents returns a new instance of Events without subscribers
+func NewEvents() *Events {
+	return &Events{}
+}
+
+// OnContactAdded calls handler when a contact enters a bucket,
+// including replacements promoted into it
+func (events *Events) OnContactAdded(handler func(Contact)) {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	events.contactAdded = append(events.contactAdded, handler)
+}
+
+// OnContactEvicted calls handler when a contact leaves a bucket,
+// because it failed too often or was never verified
+func (events *Events) OnContactEvicted(handler func(Contact)) {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	events.contactEvicted = append(events.contactEvicted, handler)
+}
+
+// OnStore calls handler when an object has been stored
+func (events *Events) OnStore(handler func(hash string, data []byte)) {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	events.stored = append(events.stored, handler)
+}
+
+func (events *Events) emitContactAdded(contact Contact) {
+	if events == nil {
+		return
+	}
+	events.mu.RLock()
+	defer events.mu.RUnlock()
+	for _, handler := range events.contactAdded {
+		handler(contact)
+	}
+}
+
+func (events *Events) emitContactEvicted(contact Contact) {
+	if events == nil {
+		return
+	}
+	events.mu.RLock()
+	defer events.mu.RUnlock()
+	for _, handler := range events.contactEvicted {
+		handler(contact)
+	}
+}
+
+func (events *Events) emitStored(hash string, data []byte) {
+	if events == nil {
+		return
+	}
+	events.mu.RLock()
+	defer events.mu.RUnlock()
+	for _, handler := range events.stored {
+		handler(hash, data)
+	}
+}
+
+// eventStorage definition
+// emits OnStore for every object successfully put in the wrapped Storage
+type eventStorage struct {
+	Storage
+	events *Events
+}
+
+// Put stores data in the wrapped Storage and emits OnStore
+func (storage *eventStorage) Put(hash string, data []byte) error {
+	if err := storage.Storage.Put(hash, data); err != nil {
+		return err
+	}
+	storage.events.emitStored(hash, data)
+	return nil
+}
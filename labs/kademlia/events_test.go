@@ -0,0 +1,35 @@
+package kademlia
+
+import "testing"
+
+func TestEvents(t *testing.T) {
+	events := NewEvents()
+	var added, evicted []Contact
+	var stored []string
+	events.OnContactAdded(func(contact Contact) { added = append(added, contact) })
+	events.OnContactEvicted(func(contact Contact) { evicted = append(evicted, contact) })
+	events.OnStore(func(hash string, data []byte) { stored = append(stored, hash) })
+
+	rt := NewRoutingTable(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"))
+	rt.SetEvents(events)
+	contact := NewContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001")
+	rt.AddContact(contact)
+	rt.AddContact(contact) // already known, not added again
+	for i := 0; i < maxContactFailures; i++ {
+		rt.RecordFailure(contact.ID)
+	}
+	if len(added) != 1 || len(evicted) != 1 || !evicted[0].ID.Equals(contact.ID) {
+		t.Fatalf("Expected the contact to be added and evicted once but got %d and %d", len(added), len(evicted))
+	}
+
+	kademlia := NewKademlia(nil)
+	kademlia.SetEvents(events)
+	kademlia.SetEvents(events) // doesn't emit twice
+	hash := NewRandomKademliaID().String()
+	if err := kademlia.storage.Put(hash, []byte("data")); err != nil {
+		t.Fatalf("Failed to store: %v", err)
+	}
+	if len(stored) != 1 || stored[0] != hash {
+		t.Fatalf("Expected one OnStore for hash but got %v", stored)
+	}
+}
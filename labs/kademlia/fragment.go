@@ -0,0 +1,229 @@
+package kademlia
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxDatagramSize is the largest UDP datagram a node sends or reads
+const maxDatagramSize = 1000
+
+// fragmentHeaderSize is the size of the transfer id, the fragment
+// index and the fragment count that prefix every fragment
+const fragmentHeaderSize = 8
+
+// maxFragmentPayload is how much of a payload fits in one fragment
+const maxFragmentPayload = maxDatagramSize - fragmentHeaderSize
+
+// maxFragments bounds the size of a payload, about 1 MB, and
+// maxPendingTransfers the number of incomplete transfers kept, so
+// fragments from the network can't make a node allocate without bound
+const (
+	maxFragments        = 1024
+	maxPendingTransfers = 64
+)
+
+// ErrPayloadTooLarge is returned when a payload needs more
+// than maxFragments fragments
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// FragmentStats counts fragmented transfers in one direction,
+// HeaderBytes/PayloadBytes is the overhead of fragmenting
+type FragmentStats struct {
+	Transfers    int // payloads split, or reassembled
+	Fragments    int
+	PayloadBytes int
+	HeaderBytes  int
+	Dropped      int // incomplete transfers that timed out or didn't fit
+}
+
+// Overhead returns the header bytes sent per payload byte
+func (stats FragmentStats) Overhead() float64 {
+	if stats.PayloadBytes == 0 {
+		return 0
+	}
+	return float64(stats.HeaderBytes) / float64(stats.PayloadBytes)
+}
+
+// Fragmenter definition
+// splits RPC payloads that don't fit in a datagram into sequenced fragments
+type Fragmenter struct {
+	mu     sync.Mutex
+	nextID uint32
+	stats  FragmentStats
+}
+
+// NewFragmenter returns a new instance of a Fragmenter
+func NewFragmenter() *Fragmenter {
+	return &Fragmenter{}
+}
+
+// Split returns payload as fragments of at most maxDatagramSize bytes
+// sharing a new transfer id. An empty payload is a single empty fragment
+func (fragmenter *Fragmenter) Split(payload []byte) ([][]byte, error) {
+	count := (len(payload) + maxFragmentPayload - 1) / maxFragmentPayload
+	if count == 0 {
+		count = 1
+	}
+	if count > maxFragments {
+		return nil, ErrPayloadTooLarge
+	}
+
+	fragmenter.mu.Lock()
+	defer fragmenter.mu.Unlock()
+	fragmenter.nextID++
+	id := fragmenter.nextID
+
+	fragments := make([][]byte, count)
+	for i := range fragments {
+		chunk := payload[i*maxFragmentPayload:]
+		if len(chunk) > maxFragmentPayload {
+			chunk = chunk[:maxFragmentPayload]
+		}
+		fragment := make([]byte, fragmentHeaderSize+len(chunk))
+		binary.BigEndian.PutUint32(fragment[0:4], id)
+		binary.BigEndian.PutUint16(fragment[4:6], uint16(i))
+		binary.BigEndian.PutUint16(fragment[6:8], uint16(count))
+		copy(fragment[fragmentHeaderSize:], chunk)
+		fragments[i] = fragment
+	}
+
+	fragmenter.stats.Transfers++
+	fragmenter.stats.Fragments += count
+	fragmenter.stats.PayloadBytes += len(payload)
+	fragmenter.stats.HeaderBytes += count * fragmentHeaderSize
+	return fragments, nil
+}
+
+// Stats returns what the Fragmenter has split so far
+func (fragmenter *Fragmenter) Stats() FragmentStats {
+	fragmenter.mu.Lock()
+	defer fragmenter.mu.Unlock()
+	return fragmenter.stats
+}
+
+// Reassembler definition
+// collects the fragments of each transfer until it is complete,
+// dropping transfers that stay incomplete for longer than timeout
+type Reassembler struct {
+	mu        sync.Mutex
+	timeout   time.Duration
+	transfers map[transferKey]*transfer
+	stats     FragmentStats
+	clock     Clock
+}
+
+// transferKey identifies a transfer, ids are only unique per sender
+type transferKey struct {
+	from string
+	id   uint32
+}
+
+// transfer is a partially received payload
+type transfer struct {
+	started   time.Time
+	count     int
+	fragments map[uint16][]byte
+}
+
+// NewReassembler returns a new instance of a Reassembler
+func NewReassembler(timeout time.Duration) *Reassembler {
+	return &Reassembler{timeout: timeout, transfers: make(map[transferKey]*transfer), clock: realClock{}}
+}
+
+// SetClock makes the Reassembler time out transfers by clock
+func (reassembler *Reassembler) SetClock(clock Clock) {
+	reassembler.mu.Lock()
+	defer reassembler.mu.Unlock()
+	reassembler.clock = clock
+}
+
+// Add adds a fragment received from the address from, returning the
+// whole payload once its last fragment has arrived
+func (reassembler *Reassembler) Add(from string, datagram []byte) (payload []byte, complete bool, err error) {
+	id, index, count, chunk, err := decodeFragment(datagram)
+	if err != nil {
+		return nil, false, err
+	}
+
+	reassembler.mu.Lock()
+	defer reassembler.mu.Unlock()
+
+	now := reassembler.clock.Now()
+	reassembler.expire(now)
+
+	key := transferKey{from, id}
+	t, exists := reassembler.transfers[key]
+	if !exists {
+		if len(reassembler.transfers) >= maxPendingTransfers {
+			reassembler.stats.Dropped++
+			return nil, false, fmt.Errorf("too many incomplete transfers, dropping transfer %d", id)
+		}
+		t = &transfer{started: now, count: int(count), fragments: make(map[uint16][]byte)}
+		reassembler.transfers[key] = t
+	} else if t.count != int(count) {
+		return nil, false, fmt.Errorf("fragment count %d of transfer %d doesn't match %d", count, id, t.count)
+	}
+
+	reassembler.stats.Fragments++
+	reassembler.stats.HeaderBytes += fragmentHeaderSize
+	if _, duplicate := t.fragments[index]; duplicate {
+		return nil, false, nil
+	}
+	t.fragments[index] = append([]byte{}, chunk...)
+	if len(t.fragments) < t.count {
+		return nil, false, nil
+	}
+
+	delete(reassembler.transfers, key)
+	for i := 0; i < t.count; i++ {
+		payload = append(payload, t.fragments[uint16(i)]...)
+	}
+	reassembler.stats.Transfers++
+	reassembler.stats.PayloadBytes += len(payload)
+	return payload, true, nil
+}
+
+// Expire drops the transfers that have been incomplete for too long
+func (reassembler *Reassembler) Expire() {
+	reassembler.mu.Lock()
+	defer reassembler.mu.Unlock()
+	reassembler.expire(reassembler.clock.Now())
+}
+
+// Stats returns what the Reassembler has received so far
+func (reassembler *Reassembler) Stats() FragmentStats {
+	reassembler.mu.Lock()
+	defer reassembler.mu.Unlock()
+	return reassembler.stats
+}
+
+// expire drops timed out transfers, the caller must hold the lock
+func (reassembler *Reassembler) expire(now time.Time) {
+	for key, t := range reassembler.transfers {
+		if now.Sub(t.started) > reassembler.timeout {
+			delete(reassembler.transfers, key)
+			reassembler.stats.Dropped++
+		}
+	}
+}
+
+// decodeFragment splits a fragment into its header fields and its chunk
+func decodeFragment(datagram []byte) (id uint32, index, count uint16, chunk []byte, err error) {
+	if len(datagram) < fragmentHeaderSize {
+		return 0, 0, 0, nil, fmt.Errorf("fragment of %d bytes is shorter than its header", len(datagram))
+	}
+	id = binary.BigEndian.Uint32(datagram[0:4])
+	index = binary.BigEndian.Uint16(datagram[4:6])
+	count = binary.BigEndian.Uint16(datagram[6:8])
+	if count == 0 || count > maxFragments || index >= count {
+		return 0, 0, 0, nil, fmt.Errorf("invalid fragment %d of %d", index, count)
+	}
+	if len(datagram) > maxDatagramSize {
+		return 0, 0, 0, nil, fmt.Errorf("fragment of %d bytes exceeds %d", len(datagram), maxDatagramSize)
+	}
+	return id, index, count, datagram[fragmentHeaderSize:], nil
+}
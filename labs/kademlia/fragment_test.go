@@ -0,0 +1,63 @@
+package kademlia
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFragmentReassembly(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 250) // 2500 bytes, 3 fragments
+	fragmenter := NewFragmenter()
+	fragments, err := fragmenter.Split(payload)
+	if err != nil {
+		t.Fatalf("Failed to split payload: %v", err)
+	}
+	if len(fragments) != 3 {
+		t.Fatalf("Expected 3 fragments but got %d", len(fragments))
+	}
+
+	// Out of order and with a duplicate
+	reassembler := NewReassembler(time.Second)
+	for _, i := range []int{2, 0, 2, 1} {
+		if len(fragments[i]) > maxDatagramSize {
+			t.Fatalf("Fragment %d is %d bytes", i, len(fragments[i]))
+		}
+		got, complete, err := reassembler.Add("localhost:8001", fragments[i])
+		if err != nil {
+			t.Fatalf("Failed to add fragment %d: %v", i, err)
+		}
+		if complete != (i == 1) {
+			t.Fatalf("Unexpected completion %v after fragment %d", complete, i)
+		}
+		if complete && !bytes.Equal(got, payload) {
+			t.Fatal("Expected the reassembled payload to match")
+		}
+	}
+
+	if stats := fragmenter.Stats(); stats.HeaderBytes != 3*fragmentHeaderSize || stats.Overhead() <= 0 {
+		t.Fatalf("Unexpected fragmenter stats %+v", stats)
+	}
+	if stats := reassembler.Stats(); stats.Transfers != 1 || stats.Fragments != 4 {
+		t.Fatalf("Unexpected reassembler stats %+v", stats)
+	}
+
+	if _, _, err := reassembler.Add("localhost:8001", []byte{1, 2, 3}); err == nil {
+		t.Fatal("Expected a truncated fragment to be rejected")
+	}
+}
+
+func TestReassemblerDropsIncompleteTransfers(t *testing.T) {
+	fragments, _ := NewFragmenter().Split(make([]byte, 2*maxFragmentPayload))
+	reassembler := NewReassembler(10 * time.Millisecond)
+	reassembler.Add("localhost:8001", fragments[0])
+
+	time.Sleep(20 * time.Millisecond)
+	reassembler.Expire()
+	if _, complete, _ := reassembler.Add("localhost:8001", fragments[1]); complete {
+		t.Fatal("Expected the timed out transfer to be dropped")
+	}
+	if stats := reassembler.Stats(); stats.Dropped != 1 {
+		t.Fatalf("Expected 1 dropped transfer but got %d", stats.Dropped)
+	}
+}
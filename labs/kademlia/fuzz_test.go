@@ -0,0 +1,71 @@
+package kademlia
+
+import (
+	"testing"
+	"time"
+)
+
+// The fuzz targets feed arbitrary datagrams to the decoders of data
+// received from the network, which must never panic. Run one with e.g.
+// go test -fuzz FuzzReassembler ./kademlia
+
+func FuzzReassembler(f *testing.F) {
+	fragments, _ := NewFragmenter().Split(make([]byte, 3*maxFragmentPayload))
+	for _, fragment := range fragments {
+		f.Add(fragment)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0, 0, 0, 1, 0xFF, 0xFF, 0xFF, 0xFF})
+
+	reassembler := NewReassembler(time.Second)
+	f.Fuzz(func(t *testing.T, datagram []byte) {
+		payload, complete, err := reassembler.Add("localhost:8001", datagram)
+		if err != nil && complete {
+			t.Fatal("Expected a failed fragment not to complete a transfer")
+		}
+		if len(payload) > maxFragments*maxFragmentPayload {
+			t.Fatalf("Reassembled %d bytes, more than the maximum payload", len(payload))
+		}
+		if len(reassembler.transfers) > maxPendingTransfers {
+			t.Fatalf("Kept %d incomplete transfers", len(reassembler.transfers))
+		}
+	})
+}
+
+func FuzzDecodeDiscovery(f *testing.F) {
+	f.Add([]byte("d7024e probe 1111111100000000000000000000000000000000 10.0.0.1:8000"))
+	f.Add([]byte("d7024e announce zz 10.0.0.1:8000"))
+	f.Fuzz(func(t *testing.T, datagram []byte) {
+		if _, contact, ok := decodeDiscovery(datagram); ok && contact.IsVerified() {
+			t.Fatal("Expected discovered contacts to be unverified")
+		}
+	})
+}
+
+func FuzzDecodeMutableRecord(f *testing.F) {
+	identity, _ := NewIdentity()
+	f.Add(NewMutableRecord(identity, 1, []byte("value")).Encode())
+	f.Add([]byte(`{"public_key":null}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		record, err := DecodeMutableRecord(data)
+		if err != nil {
+			return
+		}
+		record.Key()
+		record.Verify()
+	})
+}
+
+func FuzzDecodeTombstone(f *testing.F) {
+	identity, _ := NewIdentity()
+	f.Add(NewTombstone(identity, NewRandomKademliaID().String(), time.Now()).Encode())
+	f.Add([]byte(`{"hash":"../escape"}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tombstone, err := DecodeTombstone(data)
+		if err != nil {
+			return
+		}
+		tombstone.Publisher()
+		tombstone.Verify()
+	})
+}
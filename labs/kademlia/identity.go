@@ -0,0 +1,115 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrInvalidSignature is returned when a signature or the
+// KademliaID it claims to come from does not check out
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Identity definition
+// an ed25519 keypair and the KademliaID derived from its public key
+type Identity struct {
+	ID         *KademliaID
+	PublicKey  ed25519.PublicKey
+	privateKey ed25519.PrivateKey
+}
+
+// NewIdentity returns a new instance of an Identity with a fresh keypair
+func NewIdentity() (*Identity, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate keypair: %v", err)
+	}
+	return &Identity{IDFromPublicKey(publicKey), publicKey, privateKey}, nil
+}
+
+// identityRecord is the on-disk representation of an Identity,
+// the ID is only stored for people reading the file
+type identityRecord struct {
+	ID   string `json:"id"`
+	Seed string `json:"seed"` // hex encoded ed25519 private key seed
+}
+
+// LoadOrCreateIdentity returns the Identity saved in filename, or a new
+// one that is saved there if the file does not exist yet, so a restarted
+// node keeps its KademliaID
+func LoadOrCreateIdentity(filename string) (*Identity, error) {
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		return LoadIdentity(filename)
+	}
+	identity, err := NewIdentity()
+	if err != nil {
+		return nil, err
+	}
+	if err := identity.Save(filename); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// LoadIdentity reads an Identity written by Save
+func LoadIdentity(filename string) (*Identity, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity: %v", err)
+	}
+	var record identityRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal identity: %v", err)
+	}
+	seed, err := hex.DecodeString(record.Seed)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid identity seed in %s", filename)
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	return &Identity{IDFromPublicKey(publicKey), publicKey, privateKey}, nil
+}
+
+// Save writes the Identity, including its private key, to filename
+// readable only by the owner
+func (identity *Identity) Save(filename string) error {
+	record := identityRecord{identity.ID.String(), hex.EncodeToString(identity.privateKey.Seed())}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal identity: %v", err)
+	}
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		return fmt.Errorf("failed to write identity: %v", err)
+	}
+	return nil
+}
+
+// IDFromPublicKey returns the KademliaID belonging to publicKey,
+// the SHA-1 hash of the key
+func IDFromPublicKey(publicKey ed25519.PublicKey) *KademliaID {
+	id := KademliaID(sha1.Sum(publicKey))
+	return &id
+}
+
+// Sign returns the signature of payload
+func (identity *Identity) Sign(payload []byte) []byte {
+	return ed25519.Sign(identity.privateKey, payload)
+}
+
+// Verify returns ErrInvalidSignature unless signature is a signature of
+// payload by publicKey and publicKey belongs to id, so a sender can't
+// claim an ID it doesn't hold the key for
+func Verify(id *KademliaID, publicKey ed25519.PublicKey, payload, signature []byte) error {
+	if len(publicKey) != ed25519.PublicKeySize || !IDFromPublicKey(publicKey).Equals(id) {
+		return ErrInvalidSignature
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package kademlia
+
+import "testing"
+
+func TestIdentitySignAndVerify(t *testing.T) {
+	alice, err := NewIdentity()
+	if err != nil {
+		t.Fatalf("Failed to create identity: %v", err)
+	}
+	mallory, _ := NewIdentity()
+
+	payload := []byte("STORE hello")
+	signature := alice.Sign(payload)
+
+	if err := Verify(alice.ID, alice.PublicKey, payload, signature); err != nil {
+		t.Fatalf("Expected valid signature but got %v", err)
+	}
+	if err := Verify(alice.ID, alice.PublicKey, []byte("STORE tampered"), signature); err != ErrInvalidSignature {
+		t.Fatalf("Expected tampered payload to fail but got %v", err)
+	}
+	if err := Verify(alice.ID, mallory.PublicKey, payload, mallory.Sign(payload)); err != ErrInvalidSignature {
+		t.Fatalf("Expected spoofed ID to fail but got %v", err)
+	}
+}
+
+func TestIdentityFile(t *testing.T) {
+	filename := t.TempDir() + "/identity.json"
+	created, err := LoadOrCreateIdentity(filename)
+	if err != nil {
+		t.Fatalf("Failed to create identity: %v", err)
+	}
+
+	// A restart loads the same identity, which can still sign
+	restarted, err := LoadOrCreateIdentity(filename)
+	if err != nil {
+		t.Fatalf("Failed to load identity: %v", err)
+	}
+	if !restarted.ID.Equals(created.ID) {
+		t.Fatalf("Expected ID %s after restart but got %s", created.ID, restarted.ID)
+	}
+	payload := []byte("PING")
+	if err := Verify(created.ID, created.PublicKey, payload, restarted.Sign(payload)); err != nil {
+		t.Fatalf("Expected the restarted identity to sign for %s: %v", created.ID, err)
+	}
+}
@@ -1,16 +1,50 @@
 package kademlia
 
+import "context"
+
 type Kademlia struct {
+	config  Config
+	storage Storage
+}
+
+// NewKademlia returns a new instance of Kademlia using the DefaultConfig,
+// keeping its objects in storage, or in memory if storage is nil
+func NewKademlia(storage Storage) *Kademlia {
+	return NewKademliaWithConfig(DefaultConfig(), storage)
+}
+
+// NewKademliaWithConfig returns a new instance of Kademlia with the
+// parameters in config, keeping its objects in storage, or in memory if
+// storage is nil. The lookups keep config.K contacts and query
+// config.Alpha of them at a time
+func NewKademliaWithConfig(config Config, storage Storage) *Kademlia {
+	if storage == nil {
+		storage = NewMemoryStorage()
+	}
+	return &Kademlia{config: config, storage: storage}
+}
+
+// SetEvents makes Kademlia emit OnStore on events
+func (kademlia *Kademlia) SetEvents(events *Events) {
+	if observed, ok := kademlia.storage.(*eventStorage); ok {
+		observed.events = events
+		return
+	}
+	kademlia.storage = &eventStorage{Storage: kademlia.storage, events: events}
 }
 
-func (kademlia *Kademlia) LookupContact(target *Contact) {
+// LookupContact looks up the contacts closest to target. It is still to
+// be written, and like LookupData and Store it must give up as soon as
+// ctx is done, so callers can enforce deadlines and cancel abandoned
+// requests. Every RPC it waits for must select on ctx.Done() as well
+func (kademlia *Kademlia) LookupContact(ctx context.Context, target *Contact) {
 	// TODO
 }
 
-func (kademlia *Kademlia) LookupData(hash string) {
+func (kademlia *Kademlia) LookupData(ctx context.Context, hash string) {
 	// TODO
 }
 
-func (kademlia *Kademlia) Store(data []byte) {
+func (kademlia *Kademlia) Store(ctx context.Context, data []byte) {
 	// TODO
 }
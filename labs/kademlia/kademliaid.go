@@ -2,6 +2,8 @@ package kademlia
 
 import (
 	"encoding/hex"
+	"math/big"
+	"math/bits"
 	"math/rand"
 )
 
@@ -33,6 +35,16 @@ func NewRandomKademliaID() *KademliaID {
 	return &newKademliaID
 }
 
+// NewSeededKademliaID returns a new instance of a KademliaID drawn from
+// rng, so simulations seeding rng get the same IDs on every run
+func NewSeededKademliaID(rng *rand.Rand) *KademliaID {
+	newKademliaID := KademliaID{}
+	for i := 0; i < IDLength; i++ {
+		newKademliaID[i] = uint8(rng.Intn(256))
+	}
+	return &newKademliaID
+}
+
 // Less returns true if kademliaID < otherKademliaID (bitwise)
 func (kademliaID KademliaID) Less(otherKademliaID *KademliaID) bool {
 	for i := 0; i < IDLength; i++ {
@@ -63,6 +75,25 @@ func (kademliaID KademliaID) CalcDistance(target *KademliaID) *KademliaID {
 	return &result
 }
 
+// DistanceTo returns the XOR distance between kademliaID and other
+// as an integer
+func (kademliaID KademliaID) DistanceTo(other *KademliaID) *big.Int {
+	distance := kademliaID.CalcDistance(other)
+	return new(big.Int).SetBytes(distance[:])
+}
+
+// BucketIndex returns the position of the highest bit in which
+// kademliaID and other differ, counted from the most significant bit,
+// or IDLength*8 - 1 if they are equal
+func (kademliaID KademliaID) BucketIndex(other *KademliaID) int {
+	for i := 0; i < IDLength; i++ {
+		if diff := kademliaID[i] ^ other[i]; diff != 0 {
+			return i*8 + bits.LeadingZeros8(diff)
+		}
+	}
+	return IDLength*8 - 1
+}
+
 // String returns a simple string representation of a KademliaID
 func (kademliaID *KademliaID) String() string {
 	return hex.EncodeToString(kademliaID[0:IDLength])
@@ -0,0 +1,57 @@
+package kademlia
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+func TestDistanceToAndBucketIndexForEveryBit(t *testing.T) {
+	base := NewRandomKademliaID()
+	for bit := 0; bit < IDLength*8; bit++ {
+		other := *base
+		other[bit/8] ^= 0x80 >> uint(bit%8)
+
+		if index := base.BucketIndex(&other); index != bit {
+			t.Errorf("Expected bucket index %d but got %d", bit, index)
+		}
+		if index := other.BucketIndex(base); index != bit {
+			t.Errorf("Expected symmetric bucket index %d but got %d", bit, index)
+		}
+
+		expected := new(big.Int).Lsh(big.NewInt(1), uint(IDLength*8-1-bit))
+		if distance := base.DistanceTo(&other); distance.Cmp(expected) != 0 {
+			t.Errorf("Expected distance %v for bit %d but got %v", expected, bit, distance)
+		}
+	}
+}
+
+func TestDistanceToAndBucketIndexForEqualIDs(t *testing.T) {
+	id := NewRandomKademliaID()
+	if distance := id.DistanceTo(id); distance.Sign() != 0 {
+		t.Errorf("Expected distance 0 but got %v", distance)
+	}
+	if index := id.BucketIndex(id); index != IDLength*8-1 {
+		t.Errorf("Expected bucket index %d but got %d", IDLength*8-1, index)
+	}
+}
+
+func TestBucketIndexUsesHighestDifferingBit(t *testing.T) {
+	a := NewKademliaID("0F00000000000000000000000000000000000000")
+	b := NewKademliaID("00000000000000000000000000000000000000FF")
+	if index := a.BucketIndex(b); index != 4 {
+		t.Errorf("Expected bucket index 4 but got %d", index)
+	}
+	if a.DistanceTo(b).Cmp(new(big.Int).SetBytes(a.CalcDistance(b)[:])) != 0 {
+		t.Error("Expected DistanceTo to match CalcDistance")
+	}
+}
+
+func TestSeededKademliaIDsRepeat(t *testing.T) {
+	first, second := rand.New(rand.NewSource(42)), rand.New(rand.NewSource(42))
+	for i := 0; i < 10; i++ {
+		if a, b := NewSeededKademliaID(first), NewSeededKademliaID(second); !a.Equals(b) {
+			t.Fatalf("Expected the same IDs for the same seed but got %s and %s", a, b)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package kademlia
+
+import "context"
+
+// queryFunc asks contact for the contacts it knows closest to the target
+// of a lookup, a FIND_NODE or FIND_VALUE sent over the Network
+type queryFunc func(ctx context.Context, contact Contact) ([]Contact, error)
+
+// lookup runs an iterative lookup toward target starting from seeds,
+// keeping a Shortlist of kademlia.config.K contacts and querying at most
+// kademlia.config.Alpha of them at a time. It returns the closest
+// contacts that answered once the lookup terminates, or ctx.Err() as soon
+// as ctx is done. Every step is recorded in trace, which may be nil.
+// LookupContact and LookupData are to call it once the Network can send
+// their RPCs
+func (kademlia *Kademlia) lookup(ctx context.Context, target *KademliaID, seeds []Contact, query queryFunc, trace *LookupTrace) ([]Contact, error) {
+	type response struct {
+		contact  Contact
+		contacts []Contact
+		err      error
+	}
+	shortlist := NewShortlist(target, kademlia.config.K, seeds)
+	// At most Alpha queries are in flight, so the goroutines of those
+	// still running when the lookup returns never block
+	responses := make(chan response, kademlia.config.Alpha)
+	inFlight := 0
+
+	for !shortlist.Done() {
+		for _, contact := range shortlist.Next(kademlia.config.Alpha - inFlight) {
+			inFlight++
+			trace.Query(contact)
+			go func(contact Contact) {
+				contacts, err := query(ctx, contact)
+				responses <- response{contact, contacts, err}
+			}(contact)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case r := <-responses:
+			inFlight--
+			if r.err != nil {
+				shortlist.MarkFailed(r.contact.ID)
+				trace.Failure(r.contact, shortlist)
+				continue
+			}
+			shortlist.MarkResponded(r.contact.ID)
+			shortlist.Merge(r.contacts)
+			trace.Response(r.contact, r.contacts, shortlist)
+		}
+	}
+	return shortlist.Closest(kademlia.config.K), nil
+}
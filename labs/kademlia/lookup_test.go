@@ -0,0 +1,98 @@
+package kademlia
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLookupFindsClosestWithAlphaQueries(t *testing.T) {
+	config := Config{K: 8, Alpha: 3}
+	rng := rand.New(rand.NewSource(1))
+
+	var contacts []Contact
+	for i := 0; i < 64; i++ {
+		contacts = append(contacts, NewContact(NewSeededKademliaID(rng), "localhost:8000"))
+	}
+	// Buckets as large as the network keep every node in every table, so
+	// that the closest contacts can always be found
+	tables := make(map[KademliaID]*RoutingTable)
+	for _, contact := range contacts {
+		tables[*contact.ID] = NewRoutingTableWithConfig(contact, Config{K: len(contacts), Alpha: config.Alpha})
+		for _, other := range contacts {
+			if !other.ID.Equals(contact.ID) {
+				tables[*contact.ID].AddContact(other)
+			}
+		}
+	}
+	// a seed that has left the network since
+	gone := NewContact(NewSeededKademliaID(rng), "localhost:8000")
+
+	target := NewSeededKademliaID(rng)
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	query := func(ctx context.Context, contact Contact) ([]Contact, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if contact.ID.Equals(gone.ID) {
+			return nil, errors.New("timeout")
+		}
+		return tables[*contact.ID].FindClosestContacts(target, config.K), nil
+	}
+
+	kademlia := NewKademliaWithConfig(config, nil)
+	trace := NewLookupTrace(target)
+	found, err := kademlia.lookup(context.Background(), target, []Contact{gone, contacts[0]}, query, trace)
+	if err != nil {
+		t.Fatalf("Expected the lookup to succeed but got %v", err)
+	}
+	expected := closestByBruteForce(contacts, target, config.K)
+	if len(found) != len(expected) {
+		t.Fatalf("Expected %d contacts but got %v", len(expected), found)
+	}
+	for i := range expected {
+		if !found[i].ID.Equals(expected[i].ID) {
+			t.Fatalf("Expected %v but got %v", expected, found)
+		}
+	}
+	if maxInFlight > config.Alpha {
+		t.Errorf("Expected at most %d queries in flight but got %d", config.Alpha, maxInFlight)
+	}
+	failed := false
+	for _, step := range trace.Steps {
+		failed = failed || step.Event == TraceFailure && step.Contact == gone.ID.String()
+	}
+	if !failed {
+		t.Errorf("Expected the gone seed to be traced as failed but got %+v", trace.Steps)
+	}
+}
+
+func TestLookupGivesUpWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	defer close(release)
+	// the query only answers after the lookup has returned
+	query := func(ctx context.Context, contact Contact) ([]Contact, error) {
+		cancel()
+		<-release
+		return nil, ctx.Err()
+	}
+
+	kademlia := NewKademlia(nil)
+	seeds := []Contact{NewContact(NewRandomKademliaID(), "localhost:8000")}
+	if _, err := kademlia.lookup(ctx, NewRandomKademliaID(), seeds, query, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled but got %v", err)
+	}
+}
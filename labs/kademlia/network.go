@@ -1,6 +1,14 @@
 package kademlia
 
 type Network struct {
+	recorder *Recorder
+}
+
+// SetRecorder gives the Network a recorder for the RPCs it sends. The
+// Send methods are still to be written and must call recorder.RPC, so
+// nothing is recorded yet
+func (network *Network) SetRecorder(recorder *Recorder) {
+	network.recorder = recorder
 }
 
 func Listen(ip string, port int) {
@@ -0,0 +1,169 @@
+package kademlia
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"testing/quick"
+)
+
+// The property tests check invariants for random inputs generated by
+// testing/quick from a seed, a failure prints the seed that breaks them
+
+// randomIDNear returns a random ID sharing a random number of leading
+// bits with me, so that tables fill the buckets close to me and split
+func randomIDNear(rng *rand.Rand, me *KademliaID) *KademliaID {
+	id := NewSeededKademliaID(rng)
+	shared := rng.Intn(IDLength * 8)
+	for bit := 0; bit < shared; bit++ {
+		mask := byte(0x80 >> uint(bit%8))
+		id[bit/8] = id[bit/8]&^mask | me[bit/8]&mask
+	}
+	return id
+}
+
+// closestByBruteForce returns the count contacts in contacts closest to
+// target, by computing every distance
+func closestByBruteForce(contacts []Contact, target *KademliaID, count int) []Contact {
+	sorted := make([]Contact, len(contacts))
+	copy(sorted, contacts)
+	for i := range sorted {
+		sorted[i].CalcDistance(target)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Less(&sorted[j])
+	})
+	if len(sorted) > count {
+		sorted = sorted[:count]
+	}
+	return sorted
+}
+
+// tableContents returns every contact in the buckets of routingTable
+func tableContents(routingTable *RoutingTable) []Contact {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	var contacts []Contact
+	for _, bucket := range routingTable.buckets {
+		for e := bucket.list.Front(); e != nil; e = e.Next() {
+			contacts = append(contacts, e.Value.(Contact))
+		}
+	}
+	return contacts
+}
+
+func TestFindClosestContactsMatchesBruteForce(t *testing.T) {
+	property := func(seed int64) error {
+		rng := rand.New(rand.NewSource(seed))
+		me := NewContact(NewSeededKademliaID(rng), "localhost:8000")
+		routingTable := NewRoutingTableWithConfig(me, Config{K: 1 + rng.Intn(20), Alpha: 3})
+
+		added := rng.Intn(300)
+		for i := 0; i < added; i++ {
+			routingTable.AddContact(NewContact(randomIDNear(rng, me.ID), fmt.Sprintf("localhost:%d", 8001+i)))
+		}
+		contents := tableContents(routingTable)
+
+		for i := 0; i < 20; i++ {
+			target := randomIDNear(rng, me.ID)
+			if i%2 == 1 {
+				target = NewSeededKademliaID(rng)
+			}
+			count := 1 + rng.Intn(len(contents)+5)
+
+			got := routingTable.FindClosestContacts(target, count)
+			want := closestByBruteForce(contents, target, count)
+			if len(got) != len(want) {
+				return fmt.Errorf("seed %d: expected %d contacts for %s but got %d", seed, len(want), target, len(got))
+			}
+			for j := range want {
+				if !got[j].ID.Equals(want[j].ID) {
+					return fmt.Errorf("seed %d: contact %d closest to %s should be %s but got %s", seed, j, target, want[j].ID, got[j].ID)
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := quick.Check(func(seed int64) bool {
+		if err := property(seed); err != nil {
+			t.Error(err)
+			return false
+		}
+		return true
+	}, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRoutingTableKeepsContactsWithoutFullBuckets(t *testing.T) {
+	property := func(seed int64) bool {
+		rng := rand.New(rand.NewSource(seed))
+		me := NewContact(NewSeededKademliaID(rng), "localhost:8000")
+		k := 1 + rng.Intn(20)
+		routingTable := NewRoutingTableWithConfig(me, Config{K: k, Alpha: 3})
+
+		// never more than k distinct contacts, so no bucket ever has to drop one
+		contacts := make([]Contact, rng.Intn(k+1))
+		seen := map[KademliaID]bool{*me.ID: true}
+		for i := range contacts {
+			id := randomIDNear(rng, me.ID)
+			for seen[*id] {
+				id = randomIDNear(rng, me.ID)
+			}
+			seen[*id] = true
+			contacts[i] = NewContact(id, fmt.Sprintf("localhost:%d", 8001+i))
+			routingTable.AddContact(contacts[i])
+		}
+		return len(tableContents(routingTable)) == len(contacts) &&
+			len(routingTable.FindClosestContacts(me.ID, k)) == len(contacts)
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 200}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoredObjectsAreRetrievable(t *testing.T) {
+	property := func(seed int64, values [][]byte) bool {
+		rng := rand.New(rand.NewSource(seed))
+		disk, err := NewDiskStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("Failed to create disk storage: %v", err)
+		}
+
+		// some keys are stored more than once, the last value must win
+		keys := make([]string, 0, len(values))
+		expected := make(map[string][]byte)
+		for _, value := range values {
+			key := NewSeededKademliaID(rng).String()
+			if len(keys) > 0 && rng.Intn(4) == 0 {
+				key = keys[rng.Intn(len(keys))]
+			}
+			keys = append(keys, key)
+			expected[key] = value
+		}
+
+		for _, storage := range []Storage{NewMemoryStorage(), disk} {
+			for i, key := range keys {
+				if err := storage.Put(key, values[i]); err != nil {
+					return false
+				}
+			}
+			if storage.Len() != len(expected) {
+				return false
+			}
+			for key, value := range expected {
+				data, err := storage.Get(key)
+				if err != nil || !bytes.Equal(data, value) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 20}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,111 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrStaleRecord is returned when a MutableRecord does not have a
+// higher sequence number than the stored one
+var ErrStaleRecord = errors.New("stale record")
+
+// MutableRecord definition
+// a value its owner can update by storing a new signed record with a
+// higher sequence number, stored under the KademliaID of the owner's
+// public key instead of the hash of its content
+type MutableRecord struct {
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Sequence  uint64            `json:"seq"`
+	Value     []byte            `json:"value"`
+	Signature []byte            `json:"signature"`
+}
+
+// NewMutableRecord returns a new instance of a MutableRecord
+// holding value, signed by identity
+func NewMutableRecord(identity *Identity, sequence uint64, value []byte) MutableRecord {
+	record := MutableRecord{PublicKey: identity.PublicKey, Sequence: sequence, Value: value}
+	record.Signature = identity.Sign(record.signedPayload())
+	return record
+}
+
+// DecodeMutableRecord returns the MutableRecord encoded in data
+func DecodeMutableRecord(data []byte) (MutableRecord, error) {
+	var record MutableRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, fmt.Errorf("failed to unmarshal record: %v", err)
+	}
+	if len(record.PublicKey) != ed25519.PublicKeySize {
+		return record, errors.New("record has no valid public key")
+	}
+	return record, nil
+}
+
+// Encode returns the record as stored
+func (record MutableRecord) Encode() []byte {
+	data, _ := json.Marshal(record) // can't fail for these field types
+	return data
+}
+
+// Key returns the hash the record is stored under
+func (record MutableRecord) Key() string {
+	return IDFromPublicKey(record.PublicKey).String()
+}
+
+// Verify returns ErrInvalidSignature unless the record is
+// signed by the owner of its public key
+func (record MutableRecord) Verify() error {
+	return Verify(IDFromPublicKey(record.PublicKey), record.PublicKey, record.signedPayload(), record.Signature)
+}
+
+// signedPayload is the sequence number followed by the value, so an
+// old signature can't be replayed with another sequence number
+func (record MutableRecord) signedPayload() []byte {
+	payload := make([]byte, 8, 8+len(record.Value))
+	binary.BigEndian.PutUint64(payload, record.Sequence)
+	return append(payload, record.Value...)
+}
+
+// recordStorage definition
+// wraps a Storage and only accepts immutable objects stored under the
+// hash of their content, and valid MutableRecords stored under their Key
+type recordStorage struct {
+	Storage
+	mu sync.Mutex
+}
+
+// NewRecordStorage returns a new instance of a Storage that validates
+// records before putting them into storage. A MutableRecord replaces
+// the stored one only if its sequence number is higher
+func NewRecordStorage(storage Storage) Storage {
+	return &recordStorage{Storage: storage}
+}
+
+// Put stores data under hash unless it is not a valid record
+func (storage *recordStorage) Put(hash string, data []byte) error {
+	record, err := DecodeMutableRecord(data)
+	if err != nil || record.Key() != hash {
+		if err := ContentAddressed(hash, data); err != nil {
+			return &RejectedError{Reason: err}
+		}
+		return storage.Storage.Put(hash, data)
+	}
+
+	if err := record.Verify(); err != nil {
+		return &RejectedError{Reason: err}
+	}
+
+	// Hold the lock from reading the stored sequence number until
+	// the put, so concurrent updates can't overtake each other
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if existing, err := storage.Storage.Get(hash); err == nil {
+		if stored, err := DecodeMutableRecord(existing); err == nil && stored.Sequence >= record.Sequence {
+			return &RejectedError{Reason: ErrStaleRecord}
+		}
+	}
+	return storage.Storage.Put(hash, data)
+}
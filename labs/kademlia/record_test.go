@@ -0,0 +1,51 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestRecordStorage(t *testing.T) {
+	storage := NewRecordStorage(NewMemoryStorage())
+	owner, _ := NewIdentity()
+	other, _ := NewIdentity()
+
+	// Immutable objects are still content addressed
+	sum := sha1.Sum([]byte("hello"))
+	if err := storage.Put(hex.EncodeToString(sum[:]), []byte("hello")); err != nil {
+		t.Fatalf("Failed to store immutable object: %v", err)
+	}
+	if err := storage.Put(NewRandomKademliaID().String(), []byte("hello")); err == nil {
+		t.Fatal("Expected an object under the wrong hash to be rejected")
+	}
+
+	first := NewMutableRecord(owner, 1, []byte("v1"))
+	if err := storage.Put(first.Key(), first.Encode()); err != nil {
+		t.Fatalf("Failed to store record: %v", err)
+	}
+	second := NewMutableRecord(owner, 2, []byte("v2"))
+	if err := storage.Put(second.Key(), second.Encode()); err != nil {
+		t.Fatalf("Failed to update record: %v", err)
+	}
+
+	var rejected *RejectedError
+	if err := storage.Put(first.Key(), first.Encode()); !errors.As(err, &rejected) || rejected.Reason != ErrStaleRecord {
+		t.Fatalf("Expected the replayed old record to be stale but got %v", err)
+	}
+
+	// Someone else signing for the owner's key
+	forged := NewMutableRecord(other, 3, []byte("forged"))
+	forged.PublicKey = owner.PublicKey
+	if err := storage.Put(forged.Key(), forged.Encode()); !errors.As(err, &rejected) || rejected.Reason != ErrInvalidSignature {
+		t.Fatalf("Expected the forged record to be rejected but got %v", err)
+	}
+
+	data, _ := storage.Get(owner.ID.String())
+	record, err := DecodeMutableRecord(data)
+	if err != nil || record.Sequence != 2 || !bytes.Equal(record.Value, []byte("v2")) {
+		t.Fatalf("Expected record v2 but got %+v (%v)", record, err)
+	}
+}
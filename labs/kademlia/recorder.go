@@ -0,0 +1,239 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// RPC types
+const (
+	RPCPing      = "PING"
+	RPCFindNode  = "FIND_NODE"
+	RPCFindValue = "FIND_VALUE"
+	RPCStore     = "STORE"
+)
+
+// Recorder definition
+// records every RPC sent over the transport and snapshots of routing
+// tables, to replay a run in the visualizer of the gossip tutorial.
+// All methods do nothing on a nil *Recorder, JSON and Export return no
+// data and no error, so the transport can record whether recording is
+// enabled or not
+type Recorder struct {
+	mu        sync.Mutex
+	clock     Clock
+	Start     time.Time         `json:"start"`
+	RPCs      []RecordedRPC     `json:"rpcs"`
+	Snapshots []RoutingSnapshot `json:"snapshots"`
+}
+
+// RecordedRPC is one RPC seen by a Recorder
+type RecordedRPC struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Type        string    `json:"type"`
+	From        string    `json:"from"`
+	FromAddress string    `json:"from_address"`
+	To          string    `json:"to"`
+	ToAddress   string    `json:"to_address"`
+	Key         string    `json:"key,omitempty"` // target of a FIND_NODE, hash of a FIND_VALUE or STORE
+}
+
+// RoutingSnapshot is the content of a routing table at one point in time
+type RoutingSnapshot struct {
+	Timestamp time.Time `json:"timestamp"`
+	Node      string    `json:"node"`
+	Address   string    `json:"address"`
+	Contacts  []string  `json:"contacts"`
+}
+
+// NewRecorder returns a new instance of a Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{clock: realClock{}, Start: time.Now()}
+}
+
+// SetClock makes the Recorder timestamp by clock
+func (recorder *Recorder) SetClock(clock Clock) {
+	if recorder == nil {
+		return
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	recorder.clock = clock
+	recorder.Start = clock.Now()
+}
+
+// RPC records an RPC of rpcType from -> to, key is nil for a PING
+func (recorder *Recorder) RPC(rpcType string, from, to Contact, key *KademliaID) {
+	if recorder == nil {
+		return
+	}
+	rpc := RecordedRPC{
+		Type:        rpcType,
+		From:        from.ID.String(),
+		FromAddress: from.Address,
+		To:          to.ID.String(),
+		ToAddress:   to.Address,
+	}
+	if key != nil {
+		rpc.Key = key.String()
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	rpc.Timestamp = recorder.clock.Now()
+	recorder.RPCs = append(recorder.RPCs, rpc)
+}
+
+// Snapshot records the current contacts of routingTable
+func (recorder *Recorder) Snapshot(routingTable *RoutingTable) {
+	if recorder == nil {
+		return
+	}
+	routingTable.mu.Lock()
+	snapshot := RoutingSnapshot{Node: routingTable.me.ID.String(), Address: routingTable.me.Address}
+	for _, bucket := range routingTable.buckets {
+		for e := bucket.list.Front(); e != nil; e = e.Next() {
+			snapshot.Contacts = append(snapshot.Contacts, e.Value.(Contact).ID.String())
+		}
+	}
+	routingTable.mu.Unlock()
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	snapshot.Timestamp = recorder.clock.Now()
+	recorder.Snapshots = append(recorder.Snapshots, snapshot)
+}
+
+// JSON returns the recording as JSON
+func (recorder *Recorder) JSON() ([]byte, error) {
+	if recorder == nil {
+		return nil, nil
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	data, err := json.MarshalIndent(recorder, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal recording: %v", err)
+	}
+	return data, nil
+}
+
+// visualization is a copy of VisualizationData of the gossip tutorial,
+// the format read by its visualizer, which is another module and can't
+// be imported. The clusters and regions it has are left out since a
+// recording doesn't have them, and the visualizer does without. Nodes
+// are numbered in the order they were first seen
+type visualization struct {
+	Topology struct {
+		Nodes []visualizationNode `json:"nodes"`
+		Edges []visualizationEdge `json:"edges"`
+	} `json:"topology"`
+	Traces    []visualizationTrace `json:"traces"`
+	StartTime time.Time            `json:"startTime"`
+}
+
+type visualizationNode struct {
+	ID   int    `json:"id"`
+	Addr string `json:"addr"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+}
+
+type visualizationEdge struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+type visualizationTrace struct {
+	Timestamp          time.Time `json:"timestamp"`
+	MessageID          string    `json:"messageId"`
+	OriginalSender     int       `json:"originalSender"`
+	ImmediateForwarder int       `json:"immediateForwarder"`
+	Receiver           int       `json:"receiver"`
+	Content            string    `json:"content"`
+	TTL                int       `json:"ttl"`
+	IsDirect           bool      `json:"isDirect"`
+}
+
+// Export writes the recording to filename in the format of the
+// visualizer. Nodes are placed on a circle by ID, so nodes sharing a
+// long prefix are drawn close together, and the edges are the contacts
+// of the last snapshot of every node
+func (recorder *Recorder) Export(filename string) error {
+	if recorder == nil {
+		return nil
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	var out visualization
+	out.StartTime = recorder.Start
+	out.Topology.Nodes = []visualizationNode{}
+	out.Topology.Edges = []visualizationEdge{}
+	out.Traces = []visualizationTrace{}
+
+	indices := make(map[string]int)
+	index := func(id, address string) int {
+		if i, exists := indices[id]; exists {
+			return i
+		}
+		i := len(indices)
+		indices[id] = i
+		x, y := ringPosition(NewKademliaID(id), 600, 400, 350)
+		out.Topology.Nodes = append(out.Topology.Nodes, visualizationNode{ID: i, Addr: address, X: x, Y: y})
+		return i
+	}
+
+	for i, rpc := range recorder.RPCs {
+		from, to := index(rpc.From, rpc.FromAddress), index(rpc.To, rpc.ToAddress)
+		content := rpc.Type
+		if rpc.Key != "" {
+			content += " " + rpc.Key
+		}
+		out.Traces = append(out.Traces, visualizationTrace{
+			Timestamp:          rpc.Timestamp,
+			MessageID:          fmt.Sprintf("rpc-%d", i),
+			OriginalSender:     from,
+			ImmediateForwarder: from,
+			Receiver:           to,
+			Content:            content,
+			IsDirect:           true,
+		})
+	}
+
+	latest := make(map[string]int)
+	for i, snapshot := range recorder.Snapshots {
+		index(snapshot.Node, snapshot.Address)
+		latest[snapshot.Node] = i
+	}
+	for i, snapshot := range recorder.Snapshots {
+		if latest[snapshot.Node] != i {
+			continue // an older snapshot of the node
+		}
+		for _, contact := range snapshot.Contacts {
+			// the address of a contact that was never seen otherwise is unknown
+			out.Topology.Edges = append(out.Topology.Edges, visualizationEdge{indices[snapshot.Node], index(contact, "")})
+		}
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal visualization data: %v", err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write visualization file: %v", err)
+	}
+	return nil
+}
+
+// ringPosition returns where id goes on a circle of radius around
+// (centerX, centerY), by the first bytes of id
+func ringPosition(id *KademliaID, centerX, centerY, radius float64) (int, int) {
+	position := float64(uint32(id[0])<<24|uint32(id[1])<<16|uint32(id[2])<<8|uint32(id[3])) / (1 << 32)
+	angle := 2 * math.Pi * position
+	return int(centerX + radius*math.Cos(angle)), int(centerY + radius*math.Sin(angle))
+}
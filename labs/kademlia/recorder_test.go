@@ -0,0 +1,64 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorderExportsForTheVisualizer(t *testing.T) {
+	var disabled *Recorder
+	disabled.RPC(RPCPing, Contact{}, Contact{}, nil) // must not panic
+	if data, err := disabled.JSON(); data != nil || err != nil {
+		t.Fatalf("Expected nothing from a nil recorder but got %s, %v", data, err)
+	}
+	if err := disabled.Export(filepath.Join(t.TempDir(), "disabled.json")); err != nil {
+		t.Fatalf("Expected nothing from a nil recorder but got %v", err)
+	}
+
+	clock := NewFakeClock(time.Unix(1700000000, 0))
+	recorder := NewRecorder()
+	recorder.SetClock(clock)
+
+	alice := NewContact(NewKademliaID("0000000000000000000000000000000000000001"), "10.0.0.1:8000")
+	bob := NewContact(NewKademliaID("8000000000000000000000000000000000000000"), "10.0.0.2:8000")
+	carol := NewContact(NewKademliaID("C000000000000000000000000000000000000000"), "10.0.0.3:8000")
+
+	rt := NewRoutingTable(alice)
+	rt.AddContact(bob)
+	recorder.Snapshot(rt)
+	recorder.RPC(RPCPing, alice, bob, nil)
+	clock.Advance(time.Second)
+	recorder.RPC(RPCFindNode, alice, bob, carol.ID)
+	rt.AddContact(carol)
+	recorder.Snapshot(rt)
+
+	if len(recorder.RPCs) != 2 || recorder.RPCs[1].Key != carol.ID.String() || !recorder.RPCs[1].Timestamp.Equal(clock.Now()) {
+		t.Fatalf("Unexpected RPCs %+v", recorder.RPCs)
+	}
+	if data, err := recorder.JSON(); err != nil || !json.Valid(data) {
+		t.Fatalf("Expected valid JSON but got %v", err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "network_visualization.json")
+	if err := recorder.Export(filename); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	data, _ := os.ReadFile(filename)
+	var exported visualization
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("Failed to read the export: %v", err)
+	}
+	if len(exported.Topology.Nodes) != 3 || exported.Topology.Nodes[0].Addr != alice.Address {
+		t.Errorf("Expected alice, bob and carol as nodes but got %+v", exported.Topology.Nodes)
+	}
+	// only the last snapshot of alice counts
+	if len(exported.Topology.Edges) != 2 {
+		t.Errorf("Expected edges to bob and carol but got %+v", exported.Topology.Edges)
+	}
+	if len(exported.Traces) != 2 || exported.Traces[1].Content != RPCFindNode+" "+carol.ID.String() || exported.Traces[1].Receiver != 1 {
+		t.Errorf("Unexpected traces %+v", exported.Traces)
+	}
+}
@@ -0,0 +1,80 @@
+package kademlia
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolver definition
+// turns contact addresses, which may use host names such as docker
+// service names, into UDP addresses when dialing. Results are cached
+// for ttl, and dropped early with Invalidate when the node at a cached
+// address stops answering, since the name may point elsewhere by now
+type Resolver struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	cache   map[string]resolvedAddress
+	resolve func(address string) (*net.UDPAddr, error)
+	clock   Clock
+}
+
+// resolvedAddress is a cached result of a Resolver
+type resolvedAddress struct {
+	addr    *net.UDPAddr
+	expires time.Time
+}
+
+// NewResolver returns a new instance of a Resolver caching for ttl
+func NewResolver(ttl time.Duration) *Resolver {
+	return &Resolver{
+		ttl:   ttl,
+		cache: make(map[string]resolvedAddress),
+		clock: realClock{},
+		resolve: func(address string) (*net.UDPAddr, error) {
+			return net.ResolveUDPAddr("udp", address)
+		},
+	}
+}
+
+// SetClock makes the Resolver expire cached results by clock
+func (resolver *Resolver) SetClock(clock Clock) {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	resolver.clock = clock
+}
+
+// Resolve returns the UDP address of address, from the cache if
+// it was resolved less than ttl ago
+func (resolver *Resolver) Resolve(address string) (*net.UDPAddr, error) {
+	if err := ValidAddress(address); err != nil {
+		return nil, err
+	}
+
+	resolver.mu.Lock()
+	cached, exists := resolver.cache[address]
+	now := resolver.clock.Now()
+	resolver.mu.Unlock()
+	if exists && now.Before(cached.expires) {
+		return cached.addr, nil
+	}
+
+	// Don't hold the lock while resolving, a DNS lookup can be slow
+	addr, err := resolver.resolve(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %v", address, err)
+	}
+	resolver.mu.Lock()
+	resolver.cache[address] = resolvedAddress{addr, resolver.clock.Now().Add(resolver.ttl)}
+	resolver.mu.Unlock()
+	return addr, nil
+}
+
+// Invalidate drops the cached result for address, so the next
+// Resolve looks it up again
+func (resolver *Resolver) Invalidate(address string) {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	delete(resolver.cache, address)
+}
@@ -0,0 +1,43 @@
+package kademlia
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestResolverCachesAndReresolves(t *testing.T) {
+	resolver := NewResolver(time.Hour)
+	lookups := 0
+	ip := net.IPv4(10, 0, 0, 1)
+	resolver.resolve = func(address string) (*net.UDPAddr, error) {
+		lookups++
+		return &net.UDPAddr{IP: ip, Port: 8000}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := resolver.Resolve("kademlia-bootstrap:8000"); err != nil {
+			t.Fatalf("Failed to resolve: %v", err)
+		}
+	}
+	if lookups != 1 {
+		t.Fatalf("Expected 1 lookup thanks to the cache but got %d", lookups)
+	}
+
+	// The container restarted with a new IP and stopped answering
+	ip = net.IPv4(10, 0, 0, 2)
+	resolver.Invalidate("kademlia-bootstrap:8000")
+	addr, _ := resolver.Resolve("kademlia-bootstrap:8000")
+	if lookups != 2 || !addr.IP.Equal(ip) {
+		t.Fatalf("Expected a new lookup returning %s but got %s after %d lookups", ip, addr.IP, lookups)
+	}
+
+	if _, err := resolver.Resolve("kademlia-bootstrap"); err == nil {
+		t.Fatal("Expected an address without port to be rejected")
+	}
+
+	// The real lookup works for literals without DNS
+	if addr, err := NewResolver(time.Minute).Resolve("[::1]:8000"); err != nil || !addr.IP.Equal(net.IPv6loopback) {
+		t.Fatalf("Expected [::1]:8000 to resolve but got %v (%v)", addr, err)
+	}
+}
@@ -0,0 +1,85 @@
+package kademlia
+
+import (
+	"context"
+	"fmt"
+	mathrand "math/rand"
+	"time"
+)
+
+// RetryPolicy definition
+// how often and how fast an operation that failed is tried again
+type RetryPolicy struct {
+	MaxAttempts  int           // 0 retries until the context is done
+	InitialDelay time.Duration // delay before the second attempt
+	MaxDelay     time.Duration // the delay doubles up to MaxDelay, 0 for no cap
+	Jitter       float64       // fraction of the delay that is randomized, 0 to 1
+	Clock        Clock         // waits between attempts, the real clock if nil
+}
+
+// DefaultRetryPolicy is a reasonable RetryPolicy for RPCs over UDP
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  3,
+	InitialDelay: 500 * time.Millisecond,
+	MaxDelay:     10 * time.Second,
+	Jitter:       0.2,
+}
+
+// Validate returns an error if a field is out of range, or if the
+// policy would retry forever without waiting between attempts
+func (policy RetryPolicy) Validate() error {
+	if policy.MaxAttempts < 0 || policy.InitialDelay < 0 || policy.MaxDelay < 0 {
+		return fmt.Errorf("attempts and delays can't be negative, got %+v", policy)
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1, got %v", policy.Jitter)
+	}
+	if policy.MaxAttempts == 0 && policy.InitialDelay == 0 {
+		return fmt.Errorf("unlimited attempts need an initial delay")
+	}
+	return nil
+}
+
+// Retry calls operation until it returns nil, the policy runs out of
+// attempts or ctx is done. The error returned wraps the last error of
+// operation, and ctx.Err() if ctx is done, so both match errors.Is
+func Retry(ctx context.Context, policy RetryPolicy, operation func() error) error {
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid retry policy: %w", err)
+	}
+	clock := policy.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	delay := policy.InitialDelay
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil {
+			return nil
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w, last error: %w", ctx.Err(), err)
+		case <-clock.After(policy.jitter(delay)):
+		}
+
+		if delay *= 2; policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
+
+// jitter spreads delay randomly by up to the Jitter fraction in
+// either direction, so nodes restarted together don't retry in lockstep
+func (policy RetryPolicy) jitter(delay time.Duration) time.Duration {
+	if policy.Jitter <= 0 {
+		return delay
+	}
+	spread := float64(delay) * policy.Jitter
+	return delay + time.Duration((mathrand.Float64()*2-1)*spread)
+}
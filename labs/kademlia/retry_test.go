@@ -0,0 +1,85 @@
+package kademlia
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond}
+
+	attempts := 0
+	err := Retry(context.Background(), policy, func() error {
+		if attempts++; attempts < 3 {
+			return errors.New("timeout")
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("Expected success on the third attempt but got %v after %d", err, attempts)
+	}
+
+	attempts = 0
+	err = Retry(context.Background(), policy, func() error {
+		attempts++
+		return errors.New("timeout")
+	})
+	if err == nil || attempts != 3 {
+		t.Fatalf("Expected to give up after 3 attempts but got %v after %d", err, attempts)
+	}
+
+	// Unlimited attempts stop when the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	timeout := errors.New("timeout")
+	err = Retry(ctx, RetryPolicy{InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond, Jitter: 0.5}, func() error {
+		return timeout
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, timeout) {
+		t.Fatalf("Expected the context error and the last error but got %v", err)
+	}
+
+	attempts = 0
+	err = Retry(context.Background(), RetryPolicy{}, func() error {
+		attempts++
+		return timeout
+	})
+	if err == nil || attempts != 0 {
+		t.Fatalf("Expected unlimited attempts without delay to be refused but got %v after %d", err, attempts)
+	}
+}
+
+func TestRetryWaitsOnTheClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	policy := RetryPolicy{MaxAttempts: 2, InitialDelay: time.Hour, Clock: clock}
+	timeout := errors.New("timeout")
+
+	attempts := make(chan struct{}, policy.MaxAttempts)
+	result := make(chan error, 1)
+	go func() {
+		result <- Retry(context.Background(), policy, func() error {
+			attempts <- struct{}{}
+			return timeout
+		})
+	}()
+
+	<-attempts
+	select {
+	case <-attempts:
+		t.Fatal("Expected no second attempt before the clock advances")
+	case <-time.After(10 * time.Millisecond):
+	}
+	for retried := false; !retried; {
+		clock.Advance(time.Hour)
+		select {
+		case <-attempts:
+			retried = true
+		case <-time.After(5 * time.Millisecond): // Retry isn't waiting yet
+		}
+	}
+	if err := <-result; !errors.Is(err, timeout) {
+		t.Fatalf("Expected the last error to be wrapped but got %v", err)
+	}
+}
@@ -1,70 +1,330 @@
 package kademlia
 
-const bucketSize = 20
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
 
+// unverifiedContactTTL is how long a Contact learned from a third party
+// is kept before it must have been verified by a direct RPC
+const unverifiedContactTTL = 2 * time.Minute
+
+// staleContactAge is how long a node may be silent before the
+// sweeper pings it, maxContactFailures is how many RPCs to it may
+// fail in a row before it is dropped
+const (
+	staleContactAge    = 15 * time.Minute
+	maxContactFailures = 3
+)
 
 // RoutingTable definition
-// keeps a refrence contact of me and an array of buckets
+// keeps a refrence contact of me and the buckets. Bucket i holds the
+// contacts sharing exactly i leading bits with me, except for the last
+// bucket which holds all contacts sharing more, the range of my own ID.
+// Only the last bucket is split when it is full, so a table starts with
+// one bucket and only grows as deep as there are nodes close to me
 type RoutingTable struct {
+	mu      sync.Mutex
 	me      Contact
-	buckets [IDLength * 8]*bucket
+	buckets []*bucket
+	bans    *BanList
+	clock   Clock
 }
 
 // NewRoutingTable returns a new instance of a RoutingTable
+// using the DefaultConfig
 func NewRoutingTable(me Contact) *RoutingTable {
-	routingTable := &RoutingTable{}
-	for i := 0; i < IDLength*8; i++ {
-		routingTable.buckets[i] = newBucket()
-	}
+	return NewRoutingTableWithConfig(me, DefaultConfig())
+}
+
+// NewRoutingTableWithConfig returns a new instance of a RoutingTable
+// with buckets of config.K contacts
+func NewRoutingTableWithConfig(me Contact, config Config) *RoutingTable {
+	routingTable := &RoutingTable{clock: realClock{}}
+	routingTable.buckets = []*bucket{newBucket(config.K)}
 	routingTable.me = me
 	return routingTable
 }
 
-// AddContact add a new contact to the correct Bucket
+// SetEvents makes the RoutingTable emit OnContactAdded and
+// OnContactEvicted on events
+func (routingTable *RoutingTable) SetEvents(events *Events) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	for _, bucket := range routingTable.buckets {
+		bucket.events = events
+	}
+}
+
+// SetClock makes the RoutingTable expire and sweep contacts by clock
+func (routingTable *RoutingTable) SetClock(clock Clock) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	routingTable.clock = clock
+	for _, bucket := range routingTable.buckets {
+		bucket.clock = clock
+	}
+}
+
+// SetBanList makes the RoutingTable refuse contacts banned by bans
+// and leave them out of FindClosestContacts. Contacts already in a
+// bucket when they are banned are dropped the next time a contact is
+// added to their bucket, so they don't hold on to a slot
+func (routingTable *RoutingTable) SetBanList(bans *BanList) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	routingTable.bans = bans
+}
+
+// AddContact add a new contact to the correct Bucket, after dropping
+// the banned contacts from it.
+// A known node keeps its address even if contact has another one, so
+// a spoofed packet can't redirect it; use AddressChanged to detect that
+// and UpdateAddress once the new address answered a challenge ping
 func (routingTable *RoutingTable) AddContact(contact Contact) {
-	bucketIndex := routingTable.getBucketIndex(contact.ID)
-	bucket := routingTable.buckets[bucketIndex]
-	bucket.AddContact(contact)
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+
+	if routingTable.bans.IsBanned(contact) {
+		return
+	}
+	for {
+		bucketIndex := routingTable.getBucketIndex(contact.ID)
+		bucket := routingTable.buckets[bucketIndex]
+		bucket.removeBanned(routingTable.bans)
+		_, known := bucket.GetContact(contact.ID)
+		if known || bucket.Len() < bucket.size || !routingTable.canSplit(bucketIndex) {
+			bucket.AddContact(contact)
+			return
+		}
+		routingTable.split()
+	}
 }
 
-// FindClosestContacts finds the count closest Contacts to the target in the RoutingTable
-func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID, count int) []Contact {
-	var candidates ContactCandidates
-	bucketIndex := routingTable.getBucketIndex(target)
-	bucket := routingTable.buckets[bucketIndex]
+// canSplit returns true if the bucket at bucketIndex covers my own ID
+// and can be split further, the caller must hold the lock
+func (routingTable *RoutingTable) canSplit(bucketIndex int) bool {
+	return bucketIndex == len(routingTable.buckets)-1 && len(routingTable.buckets) < IDLength*8
+}
 
-	candidates.Append(bucket.GetContactAndCalcDistance(target))
+// split splits the last bucket, moving the contacts that share one more
+// bit with me into a new last bucket, the caller must hold the lock
+func (routingTable *RoutingTable) split() {
+	depth := len(routingTable.buckets)
+	last := routingTable.buckets[depth-1]
+	routingTable.buckets = append(routingTable.buckets, last.split(func(contact Contact) bool {
+		return contact.ID.BucketIndex(routingTable.me.ID) >= depth
+	}))
+}
 
-	for i := 1; (bucketIndex-i >= 0 || bucketIndex+i < IDLength*8) && candidates.Len() < count; i++ {
-		if bucketIndex-i >= 0 {
-			bucket = routingTable.buckets[bucketIndex-i]
-			candidates.Append(bucket.GetContactAndCalcDistance(target))
+// GetContact returns the Contact with id, ok is false if it is unknown
+func (routingTable *RoutingTable) GetContact(id *KademliaID) (contact Contact, ok bool) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	return routingTable.buckets[routingTable.getBucketIndex(id)].GetContact(id)
+}
+
+// AddressChanged returns true if the node of contact is known
+// under another address
+func (routingTable *RoutingTable) AddressChanged(contact Contact) bool {
+	known, ok := routingTable.GetContact(contact.ID)
+	return ok && known.Address != contact.Address
+}
+
+// UpdateAddress sets the address of the known node with id, it should
+// only be called after a ping to the new address was answered by id
+func (routingTable *RoutingTable) UpdateAddress(id *KademliaID, address string) bool {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	return routingTable.buckets[routingTable.getBucketIndex(id)].UpdateAddress(id, address)
+}
+
+// RecordRTT records the round-trip time of a PING or FIND_NODE
+// answered by the node with id, returning false if it is unknown
+func (routingTable *RoutingTable) RecordRTT(id *KademliaID, rtt time.Duration) bool {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	return routingTable.buckets[routingTable.getBucketIndex(id)].RecordRTT(id, rtt)
+}
+
+// RecordFailure counts a failed RPC to the node with id and drops it
+// once maxContactFailures RPCs in a row have failed, returning true
+// if it was dropped. Any answer from the node resets the count, see AddContact
+func (routingTable *RoutingTable) RecordFailure(id *KademliaID) bool {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	bucket := routingTable.buckets[routingTable.getBucketIndex(id)]
+	if bucket.RecordFailure(id) < maxContactFailures {
+		return false
+	}
+	return bucket.RemoveContact(id)
+}
+
+// SweepStale pings every node that has not been seen for staleContactAge,
+// refreshing the ones that answer and counting a failure for the others.
+// ping must return true if the contact answered
+func (routingTable *RoutingTable) SweepStale(ping func(Contact) bool) {
+	routingTable.mu.Lock()
+	now := routingTable.clock.Now()
+	routingTable.mu.Unlock()
+	routingTable.sweep(now, ping)
+}
+
+// StartSweeper runs SweepStale every interval in the background
+// until the returned stop function is called
+func (routingTable *RoutingTable) StartSweeper(interval time.Duration, ping func(Contact) bool) (stop func()) {
+	routingTable.mu.Lock()
+	clock := routingTable.clock
+	routingTable.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-clock.After(interval):
+				routingTable.SweepStale(ping)
+			case <-done:
+				return
+			}
 		}
-		if bucketIndex+i < IDLength*8 {
-			bucket = routingTable.buckets[bucketIndex+i]
-			candidates.Append(bucket.GetContactAndCalcDistance(target))
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (routingTable *RoutingTable) sweep(now time.Time, ping func(Contact) bool) {
+	// Don't hold the lock while pinging, it can take a whole RPC timeout
+	var stale []Contact
+	routingTable.mu.Lock()
+	for _, bucket := range routingTable.buckets {
+		stale = append(stale, bucket.staleContacts(now, staleContactAge)...)
+	}
+	routingTable.mu.Unlock()
+
+	for _, contact := range stale {
+		if ping(contact) {
+			routingTable.AddContact(contact)
+		} else {
+			routingTable.RecordFailure(contact.ID)
 		}
 	}
+}
 
-	candidates.Sort()
+// FindClosestContacts finds the count closest Contacts to the target in the RoutingTable.
+// The buckets are visited from closest to farthest from the target, which
+// is its own bucket, then all buckets closer to me since they all share the
+// same first bit differing from the target, then the farther ones one by one.
+// It stops as soon as count contacts are gathered and sorts only those
+func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID, count int) []Contact {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
 
-	if count > candidates.Len() {
-		count = candidates.Len()
+	var candidates []Contact
+	gather := func(bucket *bucket) {
+		for _, contact := range bucket.GetContactAndCalcDistance(target) {
+			if !routingTable.bans.IsBanned(contact) {
+				candidates = append(candidates, contact)
+			}
+		}
 	}
 
-	return candidates.GetContacts(count)
+	bucketIndex := routingTable.getBucketIndex(target)
+	gather(routingTable.buckets[bucketIndex])
+	if len(candidates) < count {
+		for _, bucket := range routingTable.buckets[bucketIndex+1:] {
+			gather(bucket)
+		}
+	}
+	for i := bucketIndex - 1; i >= 0 && len(candidates) < count; i-- {
+		gather(routingTable.buckets[i])
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Less(&candidates[j])
+	})
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+	return candidates
 }
 
 // getBucketIndex get the correct Bucket index for the KademliaID
 func (routingTable *RoutingTable) getBucketIndex(id *KademliaID) int {
-	distance := id.CalcDistance(routingTable.me.ID)
-	for i := 0; i < IDLength; i++ {
-		for j := 0; j < 8; j++ {
-			if (distance[i]>>uint8(7-j))&0x1 != 0 {
-				return i*8 + j
-			}
+	if index := id.BucketIndex(routingTable.me.ID); index < len(routingTable.buckets) {
+		return index
+	}
+	return len(routingTable.buckets) - 1
+}
+
+// contactRecord is the on-disk representation of a Contact
+type contactRecord struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// Save writes all contacts in the RoutingTable to filename so a
+// restarted node can rejoin without a full bootstrap. The file is
+// replaced atomically, a crash while saving keeps the previous one
+func (routingTable *RoutingTable) Save(filename string) error {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+
+	var records []contactRecord
+	for _, bucket := range routingTable.buckets {
+		for e := bucket.list.Front(); e != nil; e = e.Next() {
+			contact := e.Value.(Contact)
+			records = append(records, contactRecord{contact.ID.String(), contact.Address})
 		}
 	}
 
-	return IDLength*8 - 1
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal routing table: %v", err)
+	}
+	if err := writeFileAtomic(filename, data); err != nil {
+		return fmt.Errorf("failed to write routing table: %v", err)
+	}
+	return nil
+}
+
+// Load reads contacts written by Save into the RoutingTable, or none if
+// any record is invalid. The contacts may be stale, so they are added
+// as unverified and returned so that the caller can ping them, the ones
+// that don't answer expire on their own
+func (routingTable *RoutingTable) Load(filename string) ([]Contact, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing table: %v", err)
+	}
+
+	var records []contactRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal routing table: %v", err)
+	}
+
+	// Validate every record before adding any, so a corrupt
+	// file doesn't leave the table partly loaded
+	var contacts []Contact
+	for _, record := range records {
+		decoded, err := hex.DecodeString(record.ID)
+		if err != nil || len(decoded) != IDLength {
+			return nil, fmt.Errorf("invalid contact id %q", record.ID)
+		}
+		if err := ValidAddress(record.Address); err != nil {
+			return nil, err
+		}
+		contact := NewUnverifiedContact(NewKademliaID(record.ID), record.Address)
+		if !contact.ID.Equals(routingTable.me.ID) {
+			contacts = append(contacts, contact)
+		}
+	}
+	for _, contact := range contacts {
+		routingTable.AddContact(contact)
+	}
+	return contacts, nil
 }
@@ -2,7 +2,10 @@ package kademlia
 
 import (
 	"fmt"
+	"os"
+	"sort"
 	"testing"
+	"time"
 )
 
 // FIXME: This test doesn't actually test anything. There is only one assertion
@@ -28,3 +31,304 @@ func TestRoutingTable(t *testing.T) {
 		t.Fatalf("Expected 6 contacts but instead got %d", len(contacts))
 	}
 }
+
+func TestRoutingTableSaveLoad(t *testing.T) {
+	filename := t.TempDir() + "/routingtable.json"
+	me := NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000")
+
+	rt := NewRoutingTable(me)
+	rt.AddContact(NewContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001"))
+	rt.AddContact(NewContact(NewKademliaID("2111111400000000000000000000000000000000"), "localhost:8002"))
+	if err := rt.Save(filename); err != nil {
+		t.Fatalf("Failed to save routing table: %v", err)
+	}
+
+	restored := NewRoutingTable(me)
+	loaded, err := restored.Load(filename)
+	if err != nil {
+		t.Fatalf("Failed to load routing table: %v", err)
+	}
+	if len(loaded) != 2 {
+		t.Fatalf("Expected 2 loaded contacts but instead got %d", len(loaded))
+	}
+
+	contacts := restored.FindClosestContacts(NewKademliaID("2111111400000000000000000000000000000000"), 1)
+	if contacts[0].Address != "localhost:8002" || contacts[0].IsVerified() {
+		t.Fatalf("Expected unverified contact at localhost:8002 but got %s", contacts[0].String())
+	}
+}
+
+func TestRoutingTableLoadInvalid(t *testing.T) {
+	filename := t.TempDir() + "/routingtable.json"
+	records := `[{"id": "1111111100000000000000000000000000000000", "address": "localhost:8001"},
+		{"id": "not hex", "address": "localhost:8002"}]`
+	if err := os.WriteFile(filename, []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rt := NewRoutingTable(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"))
+	if _, err := rt.Load(filename); err == nil {
+		t.Fatal("Expected an invalid record to fail the load")
+	}
+	if contacts := rt.FindClosestContacts(NewKademliaID("1111111100000000000000000000000000000000"), 20); len(contacts) != 0 {
+		t.Fatalf("Expected no contacts loaded from an invalid file but got %v", contacts)
+	}
+}
+
+func TestRoutingTableAddressChange(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"))
+	id := NewKademliaID("1111111100000000000000000000000000000000")
+	rt.AddContact(NewContact(id, "10.0.0.1:8000"))
+
+	moved := NewContact(id, "10.0.0.2:8000")
+	if !rt.AddressChanged(moved) {
+		t.Fatal("Expected the new address to be detected")
+	}
+	rt.AddContact(moved)
+	if contact, _ := rt.GetContact(id); contact.Address != "10.0.0.1:8000" {
+		t.Fatalf("Expected the unverified address change to be ignored but got %s", contact.Address)
+	}
+
+	// The challenge ping to the new address was answered
+	if !rt.UpdateAddress(id, moved.Address) {
+		t.Fatal("Expected the contact to be updated")
+	}
+	if contact, _ := rt.GetContact(id); contact.Address != "10.0.0.2:8000" {
+		t.Fatalf("Expected the verified address but got %s", contact.Address)
+	}
+	if rt.AddressChanged(moved) {
+		t.Fatal("Expected no address change after the update")
+	}
+}
+
+func TestRoutingTableSweepsDeadContacts(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"))
+	alive := NewContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001")
+	dead := NewContact(NewKademliaID("2111111400000000000000000000000000000000"), "localhost:8002")
+	rt.AddContact(alive)
+	rt.AddContact(dead)
+
+	pinged := 0
+	ping := func(contact Contact) bool {
+		pinged++
+		return contact.ID.Equals(alive.ID)
+	}
+
+	// Nothing is stale right after being added
+	rt.sweep(time.Now(), ping)
+	if pinged != 0 {
+		t.Fatalf("Expected no pings for fresh contacts but got %d", pinged)
+	}
+
+	later := time.Now().Add(2 * staleContactAge)
+	for i := 0; i < maxContactFailures; i++ {
+		if _, ok := rt.GetContact(dead.ID); !ok {
+			t.Fatalf("Expected the dead contact to survive %d failed pings", i)
+		}
+		rt.sweep(later, ping)
+	}
+
+	contacts := rt.FindClosestContacts(dead.ID, 20)
+	if len(contacts) != 1 || !contacts[0].ID.Equals(alive.ID) {
+		t.Fatalf("Expected only the live contact but got %v", contacts)
+	}
+}
+
+func TestRoutingTableConfigurableK(t *testing.T) {
+	me := NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000")
+	rt := NewRoutingTableWithConfig(me, Config{K: 5, Alpha: 1})
+
+	// All of these share the first bit differing from me, so one bucket
+	for i := 0; i < 10; i++ {
+		rt.AddContact(NewContact(NewKademliaID(fmt.Sprintf("%02x", i)+"00000000000000000000000000000000000000"), "localhost:8001"))
+	}
+	if contacts := rt.FindClosestContacts(NewKademliaID("0000000000000000000000000000000000000000"), 20); len(contacts) != 5 {
+		t.Fatalf("Expected the bucket to hold k=5 contacts but got %d", len(contacts))
+	}
+
+	if err := (Config{K: 0, Alpha: 3}).Validate(); err == nil {
+		t.Fatal("Expected k=0 to be rejected")
+	}
+}
+
+func TestRoutingTableSplitsOwnRange(t *testing.T) {
+	me := NewContact(NewRandomKademliaID(), "localhost:8000")
+	rt := NewRoutingTable(me)
+	for i := 0; i < 500; i++ {
+		rt.AddContact(NewContact(NewRandomKademliaID(), "localhost:8001"))
+	}
+
+	if len(rt.buckets) >= IDLength*8 {
+		t.Fatalf("Expected far fewer than %d buckets but got %d", IDLength*8, len(rt.buckets))
+	}
+	var stored []Contact
+	for i, bucket := range rt.buckets {
+		for e := bucket.list.Front(); e != nil; e = e.Next() {
+			contact := e.Value.(Contact)
+			if index := contact.ID.BucketIndex(me.ID); index != i && (i != len(rt.buckets)-1 || index < i) {
+				t.Fatalf("Contact with bucket index %d is in bucket %d of %d", index, i, len(rt.buckets))
+			}
+			stored = append(stored, contact)
+		}
+	}
+
+	// Still finds the closest of the stored contacts
+	target := NewRandomKademliaID()
+	want := NewShortlist(target, 20, stored).Closest(20)
+	got := rt.FindClosestContacts(target, 20)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d contacts but got %d", len(want), len(got))
+	}
+	for i := range want {
+		if !got[i].ID.Equals(want[i].ID) {
+			t.Fatalf("Expected %s at %d but got %s", want[i].String(), i, got[i].String())
+		}
+	}
+}
+
+// flatRoutingTable is the routing table before it became a tree, one
+// bucket for each of the IDLength*8 prefix lengths allocated upfront,
+// kept as the baseline of BenchmarkRoutingTable
+type flatRoutingTable struct {
+	me      Contact
+	buckets [IDLength * 8]*bucket
+}
+
+func newFlatRoutingTable(me Contact) *flatRoutingTable {
+	routingTable := &flatRoutingTable{me: me}
+	for i := range routingTable.buckets {
+		routingTable.buckets[i] = newBucket(DefaultConfig().K)
+	}
+	return routingTable
+}
+
+func (routingTable *flatRoutingTable) AddContact(contact Contact) {
+	routingTable.buckets[routingTable.me.ID.BucketIndex(contact.ID)].AddContact(contact)
+}
+
+// FindClosestContacts gathers the buckets around the one of target
+// until it has count contacts, and sorts them
+func (routingTable *flatRoutingTable) FindClosestContacts(target *KademliaID, count int) []Contact {
+	bucketIndex := routingTable.me.ID.BucketIndex(target)
+	candidates := routingTable.buckets[bucketIndex].GetContactAndCalcDistance(target)
+	for i := 1; (bucketIndex-i >= 0 || bucketIndex+i < IDLength*8) && len(candidates) < count; i++ {
+		if bucketIndex-i >= 0 {
+			candidates = append(candidates, routingTable.buckets[bucketIndex-i].GetContactAndCalcDistance(target)...)
+		}
+		if bucketIndex+i < IDLength*8 {
+			candidates = append(candidates, routingTable.buckets[bucketIndex+i].GetContactAndCalcDistance(target)...)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Less(&candidates[j]) })
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+	return candidates
+}
+
+// BenchmarkRoutingTable compares the tree to the flat table it replaced.
+// It reports the memory to build a table and how many of the true k
+// closest of all added contacts the table returns, and for the tree the
+// number of buckets it grows to, the flat table always has IDLength*8
+func BenchmarkRoutingTable(b *testing.B) {
+	type table interface {
+		AddContact(contact Contact)
+		FindClosestContacts(target *KademliaID, count int) []Contact
+	}
+	tables := []struct {
+		name string
+		new  func(me Contact) table
+	}{
+		{"tree", func(me Contact) table { return NewRoutingTable(me) }},
+		{"flat", func(me Contact) table { return newFlatRoutingTable(me) }},
+	}
+
+	for _, n := range []int{100, 1000, 10000} {
+		me := NewContact(NewRandomKademliaID(), "localhost:8000")
+		contacts := make([]Contact, n)
+		for i := range contacts {
+			contacts[i] = NewContact(NewRandomKademliaID(), "localhost:8001")
+		}
+		targets := make([]*KademliaID, 100)
+		for i := range targets {
+			targets[i] = NewRandomKademliaID()
+		}
+
+		for _, tt := range tables {
+			b.Run(fmt.Sprintf("table=%s/contacts=%d", tt.name, n), func(b *testing.B) {
+				b.ReportAllocs()
+				var rt table
+				for i := 0; i < b.N; i++ {
+					rt = tt.new(me)
+					for _, contact := range contacts {
+						rt.AddContact(contact)
+					}
+				}
+				b.StopTimer()
+
+				found, total := 0, 0
+				for _, target := range targets {
+					want := NewShortlist(target, 20, contacts).Closest(20)
+					got := rt.FindClosestContacts(target, 20)
+					for _, w := range want {
+						for _, g := range got {
+							if g.ID.Equals(w.ID) {
+								found++
+								break
+							}
+						}
+					}
+					total += len(want)
+				}
+				if tree, ok := rt.(*RoutingTable); ok {
+					b.ReportMetric(float64(len(tree.buckets)), "buckets")
+				}
+				b.ReportMetric(float64(found)/float64(total), "accuracy")
+			})
+		}
+	}
+}
+
+// BenchmarkFindClosestContacts compares FindClosestContacts to sorting
+// every contact in the table by distance
+func BenchmarkFindClosestContacts(b *testing.B) {
+	rt := NewRoutingTable(NewContact(NewRandomKademliaID(), "localhost:8000"))
+	for i := 0; i < 10000; i++ {
+		rt.AddContact(NewContact(NewRandomKademliaID(), "localhost:8001"))
+	}
+	targets := make([]*KademliaID, 100)
+	for i := range targets {
+		targets[i] = NewRandomKademliaID()
+	}
+
+	b.Run("walk", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			rt.FindClosestContacts(targets[i%len(targets)], 20)
+		}
+	})
+	b.Run("sort-all", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			target := targets[i%len(targets)]
+			var all []Contact
+			for _, bucket := range rt.buckets {
+				all = append(all, bucket.GetContactAndCalcDistance(target)...)
+			}
+			sort.Slice(all, func(i, j int) bool { return all[i].Less(&all[j]) })
+		}
+	})
+}
+
+func TestRoutingTableRecordsRTT(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewKademliaID("FFFFFFFF00000000000000000000000000000000"), "localhost:8000"))
+	contact := NewContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001")
+	rt.AddContact(contact)
+
+	rt.RecordRTT(contact.ID, 80*time.Millisecond)
+	rt.RecordRTT(contact.ID, 160*time.Millisecond)
+	rt.AddContact(contact) // hearing from it again keeps the RTT
+	if known, _ := rt.GetContact(contact.ID); known.RTT() != 90*time.Millisecond {
+		t.Fatalf("Expected a smoothed RTT of 90ms but got %v", known.RTT())
+	}
+}
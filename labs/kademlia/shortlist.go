@@ -0,0 +1,199 @@
+package kademlia
+
+import (
+	"sort"
+	"sync"
+)
+
+// ContactState is the state of a Contact during an iterative lookup
+type ContactState int
+
+const (
+	Unqueried ContactState = iota // not asked yet
+	InFlight                      // asked, waiting for the response
+	Responded                     // answered the query
+	Failed                        // did not answer, never asked again
+)
+
+// Shortlist definition
+// keeps the k closest Contacts of an iterative lookup sorted by their
+// distance to the target, together with their lookup state.
+// It is safe for concurrent use by the goroutines querying contacts
+type Shortlist struct {
+	mu      sync.Mutex
+	target  *KademliaID
+	k       int
+	entries []shortlistEntry
+}
+
+// shortlistEntry is a Contact and its state in a Shortlist
+type shortlistEntry struct {
+	contact Contact
+	state   ContactState
+}
+
+// NewShortlist returns a new instance of a Shortlist keeping
+// the k closest contacts toward target
+func NewShortlist(target *KademliaID, k int, contacts []Contact) *Shortlist {
+	shortlist := &Shortlist{target: target, k: k}
+	shortlist.Merge(contacts)
+	return shortlist
+}
+
+// Merge adds the contacts that are not in the Shortlist yet as unqueried,
+// contacts that are already there keep their state. Unqueried contacts
+// that fall outside the k closest are dropped
+func (shortlist *Shortlist) Merge(contacts []Contact) {
+	shortlist.mu.Lock()
+	defer shortlist.mu.Unlock()
+
+	for _, contact := range contacts {
+		if shortlist.find(contact.ID) >= 0 {
+			continue
+		}
+		contact.CalcDistance(shortlist.target)
+		shortlist.entries = append(shortlist.entries, shortlistEntry{contact, Unqueried})
+	}
+
+	sort.SliceStable(shortlist.entries, func(i, j int) bool {
+		return shortlist.entries[i].contact.Less(&shortlist.entries[j].contact)
+	})
+
+	// Queried contacts are kept so they are neither asked again
+	// nor lose their pending response
+	kept := shortlist.entries[:0]
+	alive := 0
+	for _, entry := range shortlist.entries {
+		if entry.state == Unqueried && alive >= shortlist.k {
+			continue
+		}
+		if entry.state != Failed {
+			alive++
+		}
+		kept = append(kept, entry)
+	}
+	shortlist.entries = kept
+}
+
+// Done returns true when the lookup has terminated, that is when
+// each of the k closest Contacts that have not failed has responded
+func (shortlist *Shortlist) Done() bool {
+	shortlist.mu.Lock()
+	defer shortlist.mu.Unlock()
+
+	alive := 0
+	for _, entry := range shortlist.entries {
+		if alive == shortlist.k {
+			break
+		}
+		switch entry.state {
+		case Unqueried, InFlight:
+			return false
+		case Responded:
+			alive++
+		}
+	}
+	return true
+}
+
+// Next returns up to count of the closest unqueried Contacts and marks
+// them as in flight. Contacts sharing equally long prefixes with the
+// target are equally close, among those the ones with the lowest
+// measured RTT are preferred and unmeasured ones come last
+func (shortlist *Shortlist) Next(count int) []Contact {
+	shortlist.mu.Lock()
+	defer shortlist.mu.Unlock()
+
+	var unqueried []int
+	for i := range shortlist.entries {
+		if shortlist.entries[i].state == Unqueried {
+			unqueried = append(unqueried, i)
+		}
+	}
+	sort.SliceStable(unqueried, func(a, b int) bool {
+		x, y := shortlist.entries[unqueried[a]].contact, shortlist.entries[unqueried[b]].contact
+		if px, py := x.ID.BucketIndex(shortlist.target), y.ID.BucketIndex(shortlist.target); px != py {
+			return px > py
+		}
+		return x.rtt != 0 && (y.rtt == 0 || x.rtt < y.rtt)
+	})
+
+	var contacts []Contact
+	for _, i := range unqueried {
+		if len(contacts) == count {
+			break
+		}
+		shortlist.entries[i].state = InFlight
+		contacts = append(contacts, shortlist.entries[i].contact)
+	}
+	return contacts
+}
+
+// MarkResponded records that the Contact with id answered its query
+func (shortlist *Shortlist) MarkResponded(id *KademliaID) {
+	shortlist.setState(id, Responded)
+}
+
+// MarkFailed records that the Contact with id did not answer its query
+func (shortlist *Shortlist) MarkFailed(id *KademliaID) {
+	shortlist.setState(id, Failed)
+}
+
+// State returns the state of the Contact with id, ok is false
+// if it isn't in the Shortlist
+func (shortlist *Shortlist) State(id *KademliaID) (state ContactState, ok bool) {
+	shortlist.mu.Lock()
+	defer shortlist.mu.Unlock()
+
+	i := shortlist.find(id)
+	if i < 0 {
+		return Unqueried, false
+	}
+	return shortlist.entries[i].state, true
+}
+
+// Closest returns up to count of the closest Contacts that have not failed
+func (shortlist *Shortlist) Closest(count int) []Contact {
+	shortlist.mu.Lock()
+	defer shortlist.mu.Unlock()
+
+	var contacts []Contact
+	for _, entry := range shortlist.entries {
+		if len(contacts) == count {
+			break
+		}
+		if entry.state != Failed {
+			contacts = append(contacts, entry.contact)
+		}
+	}
+	return contacts
+}
+
+// Len returns the number of Contacts in the Shortlist
+func (shortlist *Shortlist) Len() int {
+	shortlist.mu.Lock()
+	defer shortlist.mu.Unlock()
+
+	return len(shortlist.entries)
+}
+
+// setState sets the state of the Contact with id if it is in the Shortlist
+func (shortlist *Shortlist) setState(id *KademliaID, state ContactState) {
+	shortlist.mu.Lock()
+	defer shortlist.mu.Unlock()
+
+	if i := shortlist.find(id); i >= 0 {
+		shortlist.entries[i].state = state
+	}
+}
+
+// find returns the index of the Contact with id, or -1,
+// the caller must hold the lock
+func (shortlist *Shortlist) find(id *KademliaID) int {
+	for i, entry := range shortlist.entries {
+		if entry.contact.ID.Equals(id) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,123 @@
+package kademlia
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShortlist(t *testing.T) {
+	target := NewKademliaID("0000000000000000000000000000000000000000")
+	near := NewContact(NewKademliaID("0000000100000000000000000000000000000000"), "localhost:8001")
+	middle := NewContact(NewKademliaID("0001000000000000000000000000000000000000"), "localhost:8002")
+	far := NewContact(NewKademliaID("F000000000000000000000000000000000000000"), "localhost:8003")
+
+	shortlist := NewShortlist(target, 3, []Contact{far, middle})
+	next := shortlist.Next(1)
+	if len(next) != 1 || !next[0].ID.Equals(middle.ID) {
+		t.Fatalf("Expected the middle contact first but got %v", next)
+	}
+
+	// A closer contact is learned while middle is in flight
+	shortlist.Merge([]Contact{near, middle})
+	if shortlist.Len() != 3 {
+		t.Fatalf("Expected 3 contacts after dedupe but got %d", shortlist.Len())
+	}
+	if state, _ := shortlist.State(middle.ID); state != InFlight {
+		t.Fatalf("Expected merge to keep middle in flight but got %v", state)
+	}
+
+	next = shortlist.Next(2)
+	if len(next) != 2 || !next[0].ID.Equals(near.ID) || !next[1].ID.Equals(far.ID) {
+		t.Fatalf("Expected near and far next but got %v", next)
+	}
+	if len(shortlist.Next(1)) != 0 {
+		t.Fatal("Expected no unqueried contacts left")
+	}
+
+	shortlist.MarkFailed(near.ID)
+	shortlist.MarkResponded(middle.ID)
+	closest := shortlist.Closest(2)
+	if len(closest) != 2 || !closest[0].ID.Equals(middle.ID) || !closest[1].ID.Equals(far.ID) {
+		t.Fatalf("Expected failed contacts to be skipped but got %v", closest)
+	}
+}
+
+func TestShortlistKeepsKClosestAndTerminates(t *testing.T) {
+	target := NewKademliaID("0000000000000000000000000000000000000000")
+	a := NewContact(NewKademliaID("0000000100000000000000000000000000000000"), "localhost:8001")
+	b := NewContact(NewKademliaID("0000010000000000000000000000000000000000"), "localhost:8002")
+	c := NewContact(NewKademliaID("0001000000000000000000000000000000000000"), "localhost:8003")
+	d := NewContact(NewKademliaID("0100000000000000000000000000000000000000"), "localhost:8004")
+
+	shortlist := NewShortlist(target, 2, []Contact{d, c, b})
+	if shortlist.Len() != 2 {
+		t.Fatalf("Expected only the 2 closest contacts but got %d", shortlist.Len())
+	}
+	if _, ok := shortlist.State(d.ID); ok {
+		t.Fatal("Expected the farthest contact to be dropped")
+	}
+
+	for _, contact := range shortlist.Next(2) {
+		shortlist.MarkResponded(contact.ID)
+	}
+	if !shortlist.Done() {
+		t.Fatal("Expected the lookup to be done once the k closest responded")
+	}
+
+	// A closer contact reopens the lookup, the failed one is replaced
+	shortlist.Merge([]Contact{a})
+	if shortlist.Done() {
+		t.Fatal("Expected a new close contact to reopen the lookup")
+	}
+	shortlist.Next(1)
+	shortlist.MarkFailed(a.ID)
+	if !shortlist.Done() {
+		t.Fatal("Expected the lookup to be done when the closer contact failed")
+	}
+}
+
+func TestShortlistConcurrentMerge(t *testing.T) {
+	target := NewRandomKademliaID()
+	shortlist := NewShortlist(target, 20, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				shortlist.Merge([]Contact{NewContact(NewRandomKademliaID(), "localhost:8000")})
+				for _, contact := range shortlist.Next(1) {
+					shortlist.MarkResponded(contact.ID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	closest := shortlist.Closest(20)
+	for i := 1; i < len(closest); i++ {
+		if closest[i].Less(&closest[i-1]) {
+			t.Fatal("Expected the shortlist to stay sorted")
+		}
+	}
+}
+
+func TestShortlistPrefersLowRTT(t *testing.T) {
+	target := NewKademliaID("0000000000000000000000000000000000000000")
+	closest := NewContact(NewKademliaID("0100000000000000000000000000000000000000"), "localhost:8001")
+	unmeasured := NewContact(NewKademliaID("8000000000000000000000000000000000000000"), "localhost:8002")
+	slow := NewContact(NewKademliaID("9000000000000000000000000000000000000000"), "localhost:8003")
+	slow.rtt = 50 * time.Millisecond
+	fast := NewContact(NewKademliaID("F000000000000000000000000000000000000000"), "localhost:8004")
+	fast.rtt = 5 * time.Millisecond
+
+	shortlist := NewShortlist(target, 20, []Contact{unmeasured, slow, fast, closest})
+	for _, want := range []Contact{closest, fast, slow, unmeasured} {
+		next := shortlist.Next(1)
+		if len(next) != 1 || !next[0].ID.Equals(want.ID) {
+			t.Fatalf("Expected %s next but got %v", want.String(), next)
+		}
+	}
+}
@@ -0,0 +1,468 @@
+package kademlia
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Storage that has no object for a hash
+var ErrNotFound = errors.New("object not found")
+
+// ErrLackOfSpace is returned by a Storage that would exceed its Quota
+var ErrLackOfSpace = errors.New("lack of space")
+
+// Storage definition
+// the backend keeping the data objects a node is responsible for,
+// keyed by the hex encoded hash of the object. Metadata can be
+// attached to an object once it is stored, and is kept when the
+// object is put again. Delete removes an object and its metadata
+type Storage interface {
+	Put(hash string, data []byte) error
+	Get(hash string) ([]byte, error)
+	Delete(hash string) error
+	PutMetadata(hash string, metadata Metadata) error
+	GetMetadata(hash string) (Metadata, error)
+	Len() int
+	Size() int
+}
+
+// Metadata is optional information about a stored object,
+// the zero value means none was attached
+type Metadata struct {
+	ContentType string    `json:"content_type,omitempty"`
+	Created     time.Time `json:"created"`
+	Publisher   string    `json:"publisher,omitempty"` // hex encoded KademliaID of the original publisher
+}
+
+// memoryStorage definition
+// keeps all objects in a map, the default Storage. Like diskStorage
+// it only accepts hex encoded KademliaIDs as hashes
+type memoryStorage struct {
+	mu       sync.RWMutex
+	objects  map[string][]byte
+	metadata map[string]Metadata
+}
+
+// NewMemoryStorage returns a new instance of a Storage kept in memory
+func NewMemoryStorage() Storage {
+	return &memoryStorage{objects: make(map[string][]byte), metadata: make(map[string]Metadata)}
+}
+
+// Put stores data under hash
+func (storage *memoryStorage) Put(hash string, data []byte) error {
+	if err := validHash(hash); err != nil {
+		return err
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.objects[hash] = append([]byte(nil), data...)
+	return nil
+}
+
+// Get returns the data stored under hash
+func (storage *memoryStorage) Get(hash string) ([]byte, error) {
+	if err := validHash(hash); err != nil {
+		return nil, err
+	}
+
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	data, exists := storage.objects[hash]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Delete removes the object stored under hash
+func (storage *memoryStorage) Delete(hash string) error {
+	if err := validHash(hash); err != nil {
+		return err
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if _, exists := storage.objects[hash]; !exists {
+		return ErrNotFound
+	}
+	delete(storage.objects, hash)
+	delete(storage.metadata, hash)
+	return nil
+}
+
+// PutMetadata attaches metadata to the object stored under hash
+func (storage *memoryStorage) PutMetadata(hash string, metadata Metadata) error {
+	if err := validHash(hash); err != nil {
+		return err
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if _, exists := storage.objects[hash]; !exists {
+		return ErrNotFound
+	}
+	storage.metadata[hash] = metadata
+	return nil
+}
+
+// GetMetadata returns the metadata of the object stored under hash
+func (storage *memoryStorage) GetMetadata(hash string) (Metadata, error) {
+	if err := validHash(hash); err != nil {
+		return Metadata{}, err
+	}
+
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	if _, exists := storage.objects[hash]; !exists {
+		return Metadata{}, ErrNotFound
+	}
+	return storage.metadata[hash], nil
+}
+
+// Len returns the number of stored objects
+func (storage *memoryStorage) Len() int {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	return len(storage.objects)
+}
+
+// Size returns the total number of bytes stored
+func (storage *memoryStorage) Size() int {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	size := 0
+	for _, data := range storage.objects {
+		size += len(data)
+	}
+	return size
+}
+
+// diskStorage definition
+// keeps one file per object in a data directory plus an index
+// of the stored hashes and their sizes, metadata is kept in a
+// <hash>.meta file next to the object
+type diskStorage struct {
+	mu    sync.RWMutex
+	dir   string
+	index map[string]int
+}
+
+// indexFile is the name of the index in the data directory
+const indexFile = "index.json"
+
+// metadataSuffix is appended to the hash for the metadata file
+const metadataSuffix = ".meta"
+
+// NewDiskStorage returns a new instance of a Storage persisted in dir,
+// objects stored by an earlier instance in the same dir are kept
+func NewDiskStorage(dir string) (Storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	storage := &diskStorage{dir: dir, index: make(map[string]int)}
+	data, err := os.ReadFile(filepath.Join(dir, indexFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read index: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &storage.index); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal index: %v", err)
+		}
+	}
+	return storage, nil
+}
+
+// Put writes data to the file for hash and records it in the index.
+// Both files are replaced atomically, a crash in between leaves an
+// object file the index doesn't know, which the next Put overwrites
+func (storage *diskStorage) Put(hash string, data []byte) error {
+	if err := validHash(hash); err != nil {
+		return err
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if err := writeFileAtomic(filepath.Join(storage.dir, hash), data); err != nil {
+		return fmt.Errorf("failed to write object: %v", err)
+	}
+	size, exists := storage.index[hash]
+	storage.index[hash] = len(data)
+	if err := storage.writeIndex(); err != nil {
+		// an object the index on disk doesn't know is left unused
+		if exists {
+			storage.index[hash] = size
+		} else {
+			delete(storage.index, hash)
+			os.Remove(filepath.Join(storage.dir, hash))
+		}
+		return err
+	}
+	return nil
+}
+
+// Get reads the data stored under hash
+func (storage *diskStorage) Get(hash string) ([]byte, error) {
+	if err := validHash(hash); err != nil {
+		return nil, err
+	}
+
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	if _, exists := storage.index[hash]; !exists {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(storage.dir, hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %v", err)
+	}
+	return data, nil
+}
+
+// Delete drops hash from the index and then removes its files,
+// so a crash in between leaves files the index doesn't know
+func (storage *diskStorage) Delete(hash string) error {
+	if err := validHash(hash); err != nil {
+		return err
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	size, exists := storage.index[hash]
+	if !exists {
+		return ErrNotFound
+	}
+	delete(storage.index, hash)
+	if err := storage.writeIndex(); err != nil {
+		storage.index[hash] = size
+		return err
+	}
+	if err := os.Remove(filepath.Join(storage.dir, hash)); err != nil {
+		return fmt.Errorf("failed to remove object: %v", err)
+	}
+	if err := os.Remove(filepath.Join(storage.dir, hash+metadataSuffix)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove metadata: %v", err)
+	}
+	return nil
+}
+
+// PutMetadata writes metadata to the metadata file of hash
+func (storage *diskStorage) PutMetadata(hash string, metadata Metadata) error {
+	if err := validHash(hash); err != nil {
+		return err
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if _, exists := storage.index[hash]; !exists {
+		return ErrNotFound
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(storage.dir, hash+metadataSuffix), data); err != nil {
+		return fmt.Errorf("failed to write metadata: %v", err)
+	}
+	return nil
+}
+
+// GetMetadata reads the metadata of the object stored under hash
+func (storage *diskStorage) GetMetadata(hash string) (Metadata, error) {
+	if err := validHash(hash); err != nil {
+		return Metadata{}, err
+	}
+
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	if _, exists := storage.index[hash]; !exists {
+		return Metadata{}, ErrNotFound
+	}
+	var metadata Metadata
+	data, err := os.ReadFile(filepath.Join(storage.dir, hash+metadataSuffix))
+	if os.IsNotExist(err) {
+		return metadata, nil
+	}
+	if err != nil {
+		return metadata, fmt.Errorf("failed to read metadata: %v", err)
+	}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return metadata, fmt.Errorf("failed to unmarshal metadata: %v", err)
+	}
+	return metadata, nil
+}
+
+// Len returns the number of stored objects
+func (storage *diskStorage) Len() int {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	return len(storage.index)
+}
+
+// Size returns the total number of bytes stored
+func (storage *diskStorage) Size() int {
+	storage.mu.RLock()
+	defer storage.mu.RUnlock()
+	size := 0
+	for _, length := range storage.index {
+		size += length
+	}
+	return size
+}
+
+// writeIndex persists the index, the caller must hold the lock
+func (storage *diskStorage) writeIndex() error {
+	data, err := json.Marshal(storage.index)
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %v", err)
+	}
+	if err := writeFileAtomic(filepath.Join(storage.dir, indexFile), data); err != nil {
+		return fmt.Errorf("failed to write index: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to filename and
+// renames it to filename, so a crash leaves either the old or the new
+// content and never a torn file
+func writeFileAtomic(filename string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // fails once renamed
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// validHash returns an error unless hash is a hex encoded KademliaID,
+// which also keeps it from escaping the data directory
+func validHash(hash string) error {
+	decoded, err := hex.DecodeString(hash)
+	if err != nil || len(decoded) != IDLength {
+		return fmt.Errorf("invalid hash %q", hash)
+	}
+	return nil
+}
+
+// Quota definition
+// limits the number of bytes and objects a Storage accepts,
+// a zero limit means unlimited
+type Quota struct {
+	MaxBytes   int
+	MaxObjects int
+}
+
+// quotaStorage definition
+// wraps a Storage and rejects puts exceeding the Quota
+type quotaStorage struct {
+	Storage
+	mu    sync.Mutex
+	quota Quota
+}
+
+// NewQuotaStorage returns a new instance of a Storage that puts into
+// storage until quota is reached and then fails with ErrLackOfSpace
+func NewQuotaStorage(storage Storage, quota Quota) Storage {
+	return &quotaStorage{Storage: storage, quota: quota}
+}
+
+// Put stores data under hash unless that exceeds the quota,
+// replacing an existing object only counts the difference in size
+func (storage *quotaStorage) Put(hash string, data []byte) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	addedBytes, addedObjects := len(data), 1
+	if existing, err := storage.Storage.Get(hash); err == nil {
+		addedBytes, addedObjects = len(data)-len(existing), 0
+	}
+
+	if storage.quota.MaxBytes > 0 && storage.Size()+addedBytes > storage.quota.MaxBytes {
+		return ErrLackOfSpace
+	}
+	if storage.quota.MaxObjects > 0 && storage.Len()+addedObjects > storage.quota.MaxObjects {
+		return ErrLackOfSpace
+	}
+	return storage.Storage.Put(hash, data)
+}
+
+// AdmissionPolicy decides whether an object may be stored,
+// a non-nil error is the reason it is rejected
+type AdmissionPolicy func(hash string, data []byte) error
+
+// RejectedError is returned by a Storage when an AdmissionPolicy
+// rejects an object
+type RejectedError struct {
+	Reason error
+}
+
+// Error returns the reject reason
+func (err *RejectedError) Error() string {
+	return fmt.Sprintf("object rejected: %v", err.Reason)
+}
+
+// admissionStorage definition
+// wraps a Storage and runs admission policies before each put
+type admissionStorage struct {
+	Storage
+	policies []AdmissionPolicy
+}
+
+// NewAdmissionStorage returns a new instance of a Storage that only puts
+// objects into storage that all policies accept, in order
+func NewAdmissionStorage(storage Storage, policies ...AdmissionPolicy) Storage {
+	return &admissionStorage{Storage: storage, policies: policies}
+}
+
+// Put stores data under hash unless a policy rejects it
+func (storage *admissionStorage) Put(hash string, data []byte) error {
+	for _, policy := range storage.policies {
+		if err := policy(hash, data); err != nil {
+			return &RejectedError{Reason: err}
+		}
+	}
+	return storage.Storage.Put(hash, data)
+}
+
+// MaxObjectSize returns an AdmissionPolicy rejecting objects
+// larger than max bytes
+func MaxObjectSize(max int) AdmissionPolicy {
+	return func(hash string, data []byte) error {
+		if len(data) > max {
+			return fmt.Errorf("object of %d bytes exceeds limit of %d", len(data), max)
+		}
+		return nil
+	}
+}
+
+// ContentAddressed is an AdmissionPolicy rejecting objects whose
+// hash is not the SHA-1 of their data
+func ContentAddressed(hash string, data []byte) error {
+	sum := sha1.Sum(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return errors.New("hash does not match content")
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskStorageSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	hash := NewRandomKademliaID().String()
+
+	storage, err := NewDiskStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create disk storage: %v", err)
+	}
+	if err := storage.Put(hash, []byte("hello")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	restarted, err := NewDiskStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen disk storage: %v", err)
+	}
+	data, err := restarted.Get(hash)
+	if err != nil || !bytes.Equal(data, []byte("hello")) {
+		t.Fatalf("Expected hello but got %q (%v)", data, err)
+	}
+	if restarted.Len() != 1 {
+		t.Fatalf("Expected 1 object but instead got %d", restarted.Len())
+	}
+	if _, err := restarted.Get(NewRandomKademliaID().String()); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound but got %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, ".tmp-*"))
+	if len(files) != 0 {
+		t.Fatalf("Expected no temporary files left but got %v", files)
+	}
+}
+
+func TestDiskStorageRollsBackFailedPut(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewDiskStorage(dir)
+	if err != nil {
+		t.Fatalf("Failed to create disk storage: %v", err)
+	}
+	kept := NewRandomKademliaID().String()
+	if err := storage.Put(kept, []byte("hello")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+
+	// a non-empty directory in place of the index can't be replaced
+	index := filepath.Join(dir, indexFile)
+	os.Remove(index)
+	os.MkdirAll(filepath.Join(index, "blocked"), 0755)
+
+	hash := NewRandomKademliaID().String()
+	if err := storage.Put(hash, []byte("lost")); err == nil {
+		t.Fatal("Expected the put to fail when the index can't be written")
+	}
+	if _, err := storage.Get(hash); err != ErrNotFound {
+		t.Fatalf("Expected the failed put to be rolled back but got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, hash)); !os.IsNotExist(err) {
+		t.Fatalf("Expected the object of the failed put to be removed but got %v", err)
+	}
+	if err := storage.Put(kept, []byte("hello, world")); err == nil {
+		t.Fatal("Expected the overwrite to fail when the index can't be written")
+	}
+	if storage.Len() != 1 || storage.Size() != len("hello") {
+		t.Fatalf("Expected the old size of the kept object but got %d objects of %d bytes", storage.Len(), storage.Size())
+	}
+}
+
+func TestStorageRejectsInvalidHash(t *testing.T) {
+	disk, err := NewDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create disk storage: %v", err)
+	}
+	for name, storage := range map[string]Storage{"memory": NewMemoryStorage(), "disk": disk} {
+		if err := storage.Put("../escape", []byte("x")); err == nil {
+			t.Fatalf("%s: expected invalid hash to be rejected", name)
+		}
+		if _, err := storage.Get("not hex"); err == nil || err == ErrNotFound {
+			t.Fatalf("%s: expected invalid hash to be rejected but got %v", name, err)
+		}
+	}
+}
+
+func TestQuotaStorage(t *testing.T) {
+	storage := NewQuotaStorage(NewMemoryStorage(), Quota{MaxBytes: 10, MaxObjects: 2})
+	first := NewRandomKademliaID().String()
+
+	if err := storage.Put(first, []byte("12345678")); err != nil {
+		t.Fatalf("Failed to put object: %v", err)
+	}
+	if err := storage.Put(NewRandomKademliaID().String(), []byte("123")); err != ErrLackOfSpace {
+		t.Fatalf("Expected ErrLackOfSpace for too many bytes but got %v", err)
+	}
+	if err := storage.Put(first, []byte("1234567890")); err != nil {
+		t.Fatalf("Expected replacing an object within quota to succeed but got %v", err)
+	}
+	if err := storage.Put(NewRandomKademliaID().String(), nil); err != nil {
+		t.Fatalf("Failed to put empty object: %v", err)
+	}
+	if err := storage.Put(NewRandomKademliaID().String(), nil); err != ErrLackOfSpace {
+		t.Fatalf("Expected ErrLackOfSpace for too many objects but got %v", err)
+	}
+}
+
+func TestAdmissionStorage(t *testing.T) {
+	storage := NewAdmissionStorage(NewMemoryStorage(), MaxObjectSize(5), ContentAddressed)
+	sum := sha1.Sum([]byte("hello"))
+	hash := hex.EncodeToString(sum[:])
+
+	if err := storage.Put(hash, []byte("hello")); err != nil {
+		t.Fatalf("Expected content addressed object to be accepted but got %v", err)
+	}
+
+	err := storage.Put(NewRandomKademliaID().String(), []byte("hello"))
+	if _, rejected := err.(*RejectedError); !rejected {
+		t.Fatalf("Expected RejectedError for wrong hash but got %v", err)
+	}
+
+	sum = sha1.Sum([]byte("too large"))
+	err = storage.Put(hex.EncodeToString(sum[:]), []byte("too large"))
+	if _, rejected := err.(*RejectedError); !rejected {
+		t.Fatalf("Expected RejectedError for large object but got %v", err)
+	}
+	if storage.Len() != 1 {
+		t.Fatalf("Expected 1 stored object but got %d", storage.Len())
+	}
+}
+
+func TestStorageMetadata(t *testing.T) {
+	disk, err := NewDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create disk storage: %v", err)
+	}
+	publisher := NewRandomKademliaID().String()
+
+	for name, storage := range map[string]Storage{"memory": NewMemoryStorage(), "disk": disk} {
+		hash := NewRandomKademliaID().String()
+		if err := storage.PutMetadata(hash, Metadata{ContentType: "text/plain"}); err != ErrNotFound {
+			t.Fatalf("%s: expected ErrNotFound for metadata of a missing object but got %v", name, err)
+		}
+
+		storage.Put(hash, []byte("hello"))
+		if metadata, err := storage.GetMetadata(hash); err != nil || metadata != (Metadata{}) {
+			t.Fatalf("%s: expected no metadata but got %+v (%v)", name, metadata, err)
+		}
+
+		created := time.Now().UTC().Truncate(time.Second)
+		want := Metadata{ContentType: "text/plain", Created: created, Publisher: publisher}
+		if err := storage.PutMetadata(hash, want); err != nil {
+			t.Fatalf("%s: failed to put metadata: %v", name, err)
+		}
+		storage.Put(hash, []byte("hello again")) // keeps the metadata
+		if metadata, err := storage.GetMetadata(hash); err != nil || metadata != want {
+			t.Fatalf("%s: expected %+v but got %+v (%v)", name, want, metadata, err)
+		}
+	}
+}
+
+func TestStorageDelete(t *testing.T) {
+	disk, err := NewDiskStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create disk storage: %v", err)
+	}
+	for name, storage := range map[string]Storage{"memory": NewMemoryStorage(), "disk": disk} {
+		hash := NewRandomKademliaID().String()
+		storage.Put(hash, []byte("hello"))
+		storage.PutMetadata(hash, Metadata{ContentType: "text/plain"})
+
+		if err := storage.Delete(hash); err != nil {
+			t.Fatalf("%s: failed to delete: %v", name, err)
+		}
+		if _, err := storage.Get(hash); err != ErrNotFound {
+			t.Fatalf("%s: expected ErrNotFound after delete but got %v", name, err)
+		}
+		if _, err := storage.GetMetadata(hash); err != ErrNotFound {
+			t.Fatalf("%s: expected the metadata to be deleted but got %v", name, err)
+		}
+		if storage.Len() != 0 || storage.Size() != 0 {
+			t.Fatalf("%s: expected an empty storage but got %d objects", name, storage.Len())
+		}
+		if err := storage.Delete(hash); err != ErrNotFound {
+			t.Fatalf("%s: expected ErrNotFound deleting twice but got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrDeleted is returned when an object is put again while
+// its tombstone is still kept
+var ErrDeleted = errors.New("object deleted")
+
+// Tombstone definition
+// the signed request of an object's original publisher to delete it.
+// Tombstones are sent to the replica set like objects, and keep the
+// nodes holding them from accepting the old object again while replicas
+// that missed the delete still republish it
+type Tombstone struct {
+	Hash      string            `json:"hash"`
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Deleted   time.Time         `json:"deleted"`
+	Signature []byte            `json:"signature"`
+}
+
+// NewTombstone returns a new instance of a Tombstone for the
+// object stored under hash, signed by identity
+func NewTombstone(identity *Identity, hash string, deleted time.Time) Tombstone {
+	tombstone := Tombstone{Hash: hash, PublicKey: identity.PublicKey, Deleted: deleted.UTC()}
+	tombstone.Signature = identity.Sign(tombstone.signedPayload())
+	return tombstone
+}
+
+// DecodeTombstone returns the Tombstone encoded in data
+func DecodeTombstone(data []byte) (Tombstone, error) {
+	var tombstone Tombstone
+	if err := json.Unmarshal(data, &tombstone); err != nil {
+		return tombstone, fmt.Errorf("failed to unmarshal tombstone: %v", err)
+	}
+	if err := validHash(tombstone.Hash); err != nil {
+		return tombstone, err
+	}
+	if len(tombstone.PublicKey) != ed25519.PublicKeySize {
+		return tombstone, errors.New("tombstone has no valid public key")
+	}
+	return tombstone, nil
+}
+
+// Encode returns the tombstone as sent over the network
+func (tombstone Tombstone) Encode() []byte {
+	data, _ := json.Marshal(tombstone) // can't fail for these field types
+	return data
+}
+
+// Publisher returns the hex encoded KademliaID of the signer
+func (tombstone Tombstone) Publisher() string {
+	return IDFromPublicKey(tombstone.PublicKey).String()
+}
+
+// Verify returns ErrInvalidSignature unless the tombstone is
+// signed by the owner of its public key
+func (tombstone Tombstone) Verify() error {
+	return Verify(IDFromPublicKey(tombstone.PublicKey), tombstone.PublicKey, tombstone.signedPayload(), tombstone.Signature)
+}
+
+// signedPayload is the hash followed by the deletion time, so a
+// signature can't be replayed for another object
+func (tombstone Tombstone) signedPayload() []byte {
+	payload := append([]byte("tombstone "), tombstone.Hash...)
+	return binary.BigEndian.AppendUint64(payload, uint64(tombstone.Deleted.UnixNano()))
+}
+
+// TombstoneStorage definition
+// wraps a Storage, deletes objects for valid tombstones of their
+// publisher and refuses to store them again for a grace period
+type TombstoneStorage struct {
+	Storage
+	mu         sync.Mutex
+	grace      time.Duration
+	tombstones map[string]keptTombstone
+	clock      Clock
+}
+
+// keptTombstone is a Tombstone and when it is forgotten
+type keptTombstone struct {
+	Tombstone
+	expires time.Time
+}
+
+// NewTombstoneStorage returns a new instance of a TombstoneStorage
+// putting into storage, and keeping tombstones for grace
+func NewTombstoneStorage(storage Storage, grace time.Duration) *TombstoneStorage {
+	return &TombstoneStorage{
+		Storage:    storage,
+		grace:      grace,
+		tombstones: make(map[string]keptTombstone),
+		clock:      realClock{},
+	}
+}
+
+// SetClock makes the storage expire tombstones by clock
+func (storage *TombstoneStorage) SetClock(clock Clock) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.clock = clock
+}
+
+// Bury deletes the object of tombstone and keeps the tombstone until the
+// grace period after its deletion time is over, so the node refuses the
+// object if a replica that missed the delete republishes it. Only the
+// publisher recorded in the object's Metadata may delete it, so a node
+// only buries objects it holds, and an object without publisher can't
+// be deleted. A tombstone older than the object is rejected, so an old
+// tombstone can't be replayed against a republished object. The grace
+// period counts from the deletion time and not from receipt, so passing
+// a tombstone on does not prolong it
+func (storage *TombstoneStorage) Bury(tombstone Tombstone) error {
+	if err := validHash(tombstone.Hash); err != nil {
+		return &RejectedError{Reason: err}
+	}
+	if err := tombstone.Verify(); err != nil {
+		return &RejectedError{Reason: err}
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	metadata, err := storage.Storage.GetMetadata(tombstone.Hash)
+	if err != nil {
+		return err
+	}
+	if metadata.Publisher != tombstone.Publisher() {
+		return &RejectedError{Reason: fmt.Errorf("only the publisher may delete %s", tombstone.Hash)}
+	}
+	if tombstone.Deleted.Before(metadata.Created) {
+		return &RejectedError{Reason: fmt.Errorf("tombstone of %s is older than the object", tombstone.Hash)}
+	}
+	if err := storage.Storage.Delete(tombstone.Hash); err != nil {
+		return err
+	}
+
+	// a deletion time in the future must not keep the tombstone longer
+	now := storage.clock.Now()
+	deleted := tombstone.Deleted
+	if deleted.After(now) {
+		deleted = now
+	}
+	if expires := deleted.Add(storage.grace); now.Before(expires) {
+		storage.tombstones[tombstone.Hash] = keptTombstone{tombstone, expires}
+	}
+	return nil
+}
+
+// Put stores data under hash unless hash was deleted
+// less than the grace period ago
+func (storage *TombstoneStorage) Put(hash string, data []byte) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.expire()
+	if _, exists := storage.tombstones[hash]; exists {
+		return &RejectedError{Reason: ErrDeleted}
+	}
+	return storage.Storage.Put(hash, data)
+}
+
+// Tombstones returns the tombstones still kept,
+// to be sent on to the replica set
+func (storage *TombstoneStorage) Tombstones() []Tombstone {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	storage.expire()
+	tombstones := make([]Tombstone, 0, len(storage.tombstones))
+	for _, kept := range storage.tombstones {
+		tombstones = append(tombstones, kept.Tombstone)
+	}
+	return tombstones
+}
+
+// expire forgets the tombstones past the grace period,
+// the caller must hold the lock
+func (storage *TombstoneStorage) expire() {
+	now := storage.clock.Now()
+	for hash, kept := range storage.tombstones {
+		if !now.Before(kept.expires) {
+			delete(storage.tombstones, hash)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package kademlia
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTombstoneStorage(t *testing.T) {
+	publisher, _ := NewIdentity()
+	stranger, _ := NewIdentity()
+	clock := NewFakeClock(time.Now())
+	storage := NewTombstoneStorage(NewMemoryStorage(), time.Hour)
+	storage.SetClock(clock)
+
+	data := []byte("to be deleted")
+	sum := sha1.Sum(data)
+	hash := hex.EncodeToString(sum[:])
+	storage.Put(hash, data)
+	storage.PutMetadata(hash, Metadata{Publisher: publisher.ID.String()})
+
+	var rejected *RejectedError
+	if err := storage.Bury(NewTombstone(stranger, hash, clock.Now())); !errors.As(err, &rejected) {
+		t.Fatalf("Expected a tombstone of another node to be rejected but got %v", err)
+	}
+	forged := NewTombstone(stranger, hash, clock.Now())
+	forged.PublicKey = publisher.PublicKey
+	if err := storage.Bury(forged); !errors.As(err, &rejected) || rejected.Reason != ErrInvalidSignature {
+		t.Fatalf("Expected a forged tombstone to be rejected but got %v", err)
+	}
+	if _, err := storage.Get(hash); err != nil {
+		t.Fatalf("Expected the object to survive rejected tombstones but got %v", err)
+	}
+
+	// the tombstone survives being sent over the network
+	tombstone, err := DecodeTombstone(NewTombstone(publisher, hash, clock.Now()).Encode())
+	if err != nil {
+		t.Fatalf("Failed to decode tombstone: %v", err)
+	}
+	if err := storage.Bury(tombstone); err != nil {
+		t.Fatalf("Expected the publisher's tombstone to be accepted but got %v", err)
+	}
+	if _, err := storage.Get(hash); err != ErrNotFound {
+		t.Fatalf("Expected the object to be deleted but got %v", err)
+	}
+	if tombstones := storage.Tombstones(); len(tombstones) != 1 || tombstones[0].Hash != hash {
+		t.Fatalf("Expected the tombstone to be kept but got %v", tombstones)
+	}
+
+	// a replica that missed the delete republishes the old object
+	if err := storage.Put(hash, data); !errors.As(err, &rejected) || rejected.Reason != ErrDeleted {
+		t.Fatalf("Expected ErrDeleted during the grace period but got %v", err)
+	}
+	clock.Advance(time.Hour)
+	if len(storage.Tombstones()) != 0 {
+		t.Fatal("Expected the tombstone to be forgotten after the grace period")
+	}
+	if err := storage.Put(hash, data); err != nil {
+		t.Fatalf("Expected the object to be accepted after the grace period but got %v", err)
+	}
+}
+
+func TestTombstoneStorageRejectsUnsafeTombstones(t *testing.T) {
+	publisher, _ := NewIdentity()
+	clock := NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	storage := NewTombstoneStorage(NewMemoryStorage(), time.Hour)
+	storage.SetClock(clock)
+	hash := NewRandomKademliaID().String()
+
+	// a tombstone for an object the node doesn't hold can't block it
+	if err := storage.Bury(NewTombstone(publisher, hash, clock.Now())); err != ErrNotFound {
+		t.Fatalf("Expected a tombstone for an unknown object to be refused but got %v", err)
+	}
+	if err := storage.Put(hash, []byte("v1")); err != nil {
+		t.Fatalf("Expected the object to be accepted but got %v", err)
+	}
+	storage.PutMetadata(hash, Metadata{Publisher: publisher.ID.String(), Created: clock.Now()})
+
+	// a tombstone of an earlier deletion is replayed against the republished object
+	var rejected *RejectedError
+	if err := storage.Bury(NewTombstone(publisher, hash, clock.Now().Add(-time.Minute))); !errors.As(err, &rejected) {
+		t.Fatalf("Expected a tombstone older than the object to be rejected but got %v", err)
+	}
+
+	// the grace period counts from the deletion, not from receipt
+	deleted := clock.Now().Add(10 * time.Minute)
+	clock.Advance(40 * time.Minute)
+	if err := storage.Bury(NewTombstone(publisher, hash, deleted)); err != nil {
+		t.Fatalf("Expected the tombstone to be accepted but got %v", err)
+	}
+	clock.Advance(30 * time.Minute)
+	if len(storage.Tombstones()) != 0 {
+		t.Fatal("Expected the tombstone to expire an hour after the deletion")
+	}
+}
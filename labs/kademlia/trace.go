@@ -0,0 +1,161 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trace events
+const (
+	TraceQuery    = "query"
+	TraceResponse = "response"
+	TraceFailure  = "failure"
+)
+
+// LookupTrace definition
+// records every step of an iterative lookup so it can be inspected
+// afterwards. All methods do nothing on a nil *LookupTrace, so a
+// lookup can record into its trace whether tracing is enabled or not
+type LookupTrace struct {
+	mu     sync.Mutex
+	start  time.Time
+	Target string      `json:"target"`
+	Steps  []TraceStep `json:"steps"`
+}
+
+// TraceStep is one query sent, or one response or failure
+// received, during a lookup
+type TraceStep struct {
+	ElapsedMs float64  `json:"elapsed_ms"`
+	Event     string   `json:"event"`
+	Contact   string   `json:"contact"`
+	Address   string   `json:"address"`
+	Returned  []string `json:"returned,omitempty"`  // ids in a response
+	Shortlist []string `json:"shortlist,omitempty"` // ids of the closest contacts after the step
+}
+
+// NewLookupTrace returns a new instance of a LookupTrace
+// for a lookup of target
+func NewLookupTrace(target *KademliaID) *LookupTrace {
+	return &LookupTrace{start: time.Now(), Target: target.String()}
+}
+
+// Query records that contact was asked
+func (trace *LookupTrace) Query(contact Contact) {
+	trace.add(TraceStep{Event: TraceQuery, Contact: contact.ID.String(), Address: contact.Address}, nil)
+}
+
+// Response records that contact answered with returned, shortlist
+// is the lookup's Shortlist after merging them
+func (trace *LookupTrace) Response(contact Contact, returned []Contact, shortlist *Shortlist) {
+	step := TraceStep{Event: TraceResponse, Contact: contact.ID.String(), Address: contact.Address}
+	for _, c := range returned {
+		step.Returned = append(step.Returned, c.ID.String())
+	}
+	trace.add(step, shortlist)
+}
+
+// Failure records that contact did not answer, shortlist is the
+// lookup's Shortlist after marking it as failed
+func (trace *LookupTrace) Failure(contact Contact, shortlist *Shortlist) {
+	trace.add(TraceStep{Event: TraceFailure, Contact: contact.ID.String(), Address: contact.Address}, shortlist)
+}
+
+func (trace *LookupTrace) add(step TraceStep, shortlist *Shortlist) {
+	if trace == nil {
+		return
+	}
+	if shortlist != nil {
+		for _, c := range shortlist.Closest(shortlist.k) {
+			step.Shortlist = append(step.Shortlist, c.ID.String())
+		}
+	}
+
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	step.ElapsedMs = float64(time.Since(trace.start).Microseconds()) / 1000
+	trace.Steps = append(trace.Steps, step)
+}
+
+// JSON returns the trace as indented JSON
+func (trace *LookupTrace) JSON() ([]byte, error) {
+	if trace == nil {
+		return []byte("null"), nil
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+
+	data, err := json.MarshalIndent(trace, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lookup trace: %v", err)
+	}
+	return data, nil
+}
+
+// Tree returns the trace as a human readable tree, where each contact
+// is listed under the contact whose response first returned it
+func (trace *LookupTrace) Tree() string {
+	if trace == nil {
+		return ""
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+
+	type node struct {
+		address  string
+		outcome  string
+		children []string
+	}
+	nodes := make(map[string]*node)
+	var roots []string
+	get := func(id string) *node {
+		if nodes[id] == nil {
+			nodes[id] = &node{outcome: "not queried"}
+		}
+		return nodes[id]
+	}
+
+	for _, step := range trace.Steps {
+		if _, known := nodes[step.Contact]; !known {
+			roots = append(roots, step.Contact) // from the routing table
+		}
+		n := get(step.Contact)
+		n.address = step.Address
+		switch step.Event {
+		case TraceQuery:
+			n.outcome = "queried, no answer yet"
+		case TraceResponse:
+			n.outcome = fmt.Sprintf("responded after %.1fms with %d contacts", step.ElapsedMs, len(step.Returned))
+			for _, id := range step.Returned {
+				if _, known := nodes[id]; !known {
+					get(id)
+					n.children = append(n.children, id)
+				}
+			}
+		case TraceFailure:
+			n.outcome = fmt.Sprintf("failed after %.1fms", step.ElapsedMs)
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "lookup %s\n", trace.Target)
+	var write func(id string, depth int)
+	write = func(id string, depth int) {
+		n := nodes[id]
+		label := id
+		if n.address != "" {
+			label += " " + n.address
+		}
+		fmt.Fprintf(&sb, "%s- %s: %s\n", strings.Repeat("  ", depth+1), label, n.outcome)
+		for _, child := range n.children {
+			write(child, depth+1)
+		}
+	}
+	for _, id := range roots {
+		write(id, 0)
+	}
+	return sb.String()
+}
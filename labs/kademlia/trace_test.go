@@ -0,0 +1,53 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLookupTrace(t *testing.T) {
+	target := NewKademliaID("0000000000000000000000000000000000000000")
+	first := NewContact(NewKademliaID("1111111100000000000000000000000000000000"), "localhost:8001")
+	second := NewContact(NewKademliaID("0111111100000000000000000000000000000000"), "localhost:8002")
+	dead := NewContact(NewKademliaID("0011111100000000000000000000000000000000"), "localhost:8003")
+
+	trace := NewLookupTrace(target)
+	shortlist := NewShortlist(target, 3, []Contact{first})
+	trace.Query(first)
+	shortlist.Merge([]Contact{second, dead})
+	shortlist.MarkResponded(first.ID)
+	trace.Response(first, []Contact{second, dead}, shortlist)
+	trace.Query(dead)
+	shortlist.MarkFailed(dead.ID)
+	trace.Failure(dead, shortlist)
+
+	data, err := trace.JSON()
+	if err != nil {
+		t.Fatalf("Failed to export trace: %v", err)
+	}
+	var decoded LookupTrace
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode trace: %v", err)
+	}
+	if len(decoded.Steps) != 4 {
+		t.Fatalf("Expected 4 steps but got %d", len(decoded.Steps))
+	}
+	if last := decoded.Steps[3]; last.Event != TraceFailure || len(last.Shortlist) != 2 {
+		t.Fatalf("Expected a failure leaving 2 contacts in the shortlist but got %+v", last)
+	}
+
+	tree := trace.Tree()
+	t.Log("\n" + tree)
+	lines := strings.Split(strings.TrimSpace(tree), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "    - "+second.ID.String()) {
+		t.Fatalf("Expected the returned contacts nested under the first contact but got:\n%s", tree)
+	}
+
+	// Tracing is optional, a nil trace records nothing
+	var disabled *LookupTrace
+	disabled.Query(first)
+	if disabled.Tree() != "" {
+		t.Fatal("Expected an empty tree for a nil trace")
+	}
+}
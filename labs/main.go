@@ -3,15 +3,197 @@
 package main
 
 import (
+	"context"
 	"d7024e/kademlia"
+	"encoding/hex"
+	"flag"
 	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 func main() {
-	fmt.Println("Pretending to run the kademlia app...")
-	// Using stuff from the kademlia package here. Something like...
-	id := kademlia.NewKademliaID("FFFFFFFF00000000000000000000000000000000")
-	contact := kademlia.NewContact(id, "localhost:8000")
+	if len(os.Args) > 1 && os.Args[1] == "genstack" {
+		if err := genstack(os.Args[2:]); err != nil {
+			log.Fatalf("genstack: %v", err)
+		}
+		return
+	}
+
+	listenPort := flag.Int("listen-port", envInt("LISTEN_PORT", 8000), "UDP port to listen on")
+	id := flag.String("id", os.Getenv("NODE_ID"), "hex encoded KademliaID, random if empty")
+	identityFile := flag.String("identity", os.Getenv("IDENTITY_FILE"), "file keeping the node's keypair and KademliaID across restarts, created if missing")
+	bootstrap := flag.String("bootstrap", os.Getenv("BOOTSTRAP"), "host:port of a node to join, none to start a new network. Resolving the host is retried until it succeeds, reaching the node isn't")
+	routingTableFile := flag.String("routing-table", os.Getenv("ROUTING_TABLE"), "file keeping the routing table across restarts, loaded at startup and saved at shutdown")
+	discover := flag.Bool("discover", envBool("DISCOVER"), "find nodes on the LAN through UDP broadcast and try them before --bootstrap")
+	config := kademlia.DefaultConfig()
+	flag.IntVar(&config.K, "k", envInt("K", config.K), "bucket size and number of contacts returned by a lookup")
+	flag.IntVar(&config.Alpha, "alpha", envInt("ALPHA", config.Alpha), "number of concurrent queries of a lookup")
+	flag.Parse()
+	if err := config.Validate(); err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	kademliaID := kademlia.NewRandomKademliaID()
+	switch {
+	case *id != "" && *identityFile != "":
+		log.Fatal("--id and --identity can't be used together, the identity determines the ID")
+	case *id != "":
+		if decoded, err := hex.DecodeString(*id); err != nil || len(decoded) != kademlia.IDLength {
+			log.Fatalf("--id must be %d hex encoded bytes, got %q", kademlia.IDLength, *id)
+		}
+		kademliaID = kademlia.NewKademliaID(*id)
+	case *identityFile != "":
+		identity, err := kademlia.LoadOrCreateIdentity(*identityFile)
+		if err != nil {
+			log.Fatalf("failed to load identity: %v", err)
+		}
+		kademliaID = identity.ID
+	}
+	contact := kademlia.NewContact(kademliaID, net.JoinHostPort(localIP(), strconv.Itoa(*listenPort)))
 	fmt.Println(contact.String())
-	fmt.Printf("%v\n", contact)
+	fmt.Printf("k=%d alpha=%d\n", config.K, config.Alpha)
+	routingTable := kademlia.NewRoutingTableWithConfig(contact, config)
+	node := kademlia.NewKademliaWithConfig(config, nil)
+
+	// Nodes found on the LAN answered the probe, so they are up
+	// and tried first, the --bootstrap node comes last
+	var candidates []string
+	if *discover {
+		discovery, err := kademlia.NewLocalDiscovery(contact, kademlia.DiscoveryPort)
+		if err != nil {
+			log.Fatalf("failed to start local discovery: %v", err)
+		}
+		peers, err := discovery.Probe(2 * time.Second)
+		if err != nil {
+			log.Printf("local discovery failed: %v", err)
+		}
+		for _, peer := range peers {
+			routingTable.AddContact(peer)
+			candidates = append(candidates, peer.Address)
+		}
+		fmt.Printf("found %d nodes on the LAN\n", len(peers))
+	}
+
+	// Contacts saved by the last run may have left since, so they come
+	// after the ones found on the LAN and are pinged like the others
+	if *routingTableFile != "" {
+		if _, err := os.Stat(*routingTableFile); err == nil {
+			saved, err := routingTable.Load(*routingTableFile)
+			if err != nil {
+				log.Printf("ignoring saved routing table: %v", err)
+			}
+			for _, contact := range saved {
+				candidates = append(candidates, contact.Address)
+			}
+			fmt.Printf("loaded %d contacts\n", len(saved))
+		}
+	}
+
+	if *bootstrap != "" {
+		if len(candidates) > 0 {
+			if err := kademlia.ValidAddress(*bootstrap); err != nil {
+				log.Fatalf("invalid bootstrap node: %v", err)
+			}
+			candidates = append(candidates, *bootstrap)
+		} else {
+			// Containers start in any order, so wait until the bootstrap
+			// node's name resolves. That only means its container exists,
+			// not that the node answers yet
+			addr, err := resolveWithRetry(*bootstrap)
+			if err != nil {
+				log.Fatalf("failed to resolve bootstrap node: %v", err)
+			}
+			candidates = append(candidates, addr.String())
+		}
+	}
+
+	if len(candidates) > 0 {
+		fmt.Printf("joining network through %s\n", candidates[0])
+		// TODO: ping the candidates in order and look up our own ID through
+		// the first one that answers, retrying with kademlia.Retry until one
+		// does. Nothing retries reaching the bootstrap node until then
+		node.LookupContact(context.Background(), &contact)
+	}
+
+	// TODO: serve RPCs with kademlia.Listen, until then the node only
+	// waits to be stopped
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	if *routingTableFile != "" {
+		if err := routingTable.Save(*routingTableFile); err != nil {
+			log.Printf("failed to save routing table: %v", err)
+		}
+	}
+}
+
+// envInt returns the integer value of the environment variable name,
+// or fallback if it is unset or invalid
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envBool returns the boolean value of the environment variable name,
+// or false if it is unset or invalid
+func envBool(name string) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	return err == nil && value
+}
+
+// resolveWithRetry resolves address, retrying with a doubling delay of
+// at most 10 seconds until it succeeds. A malformed address can never
+// resolve, so it fails at once
+func resolveWithRetry(address string) (*net.UDPAddr, error) {
+	if err := kademlia.ValidAddress(address); err != nil {
+		return nil, err
+	}
+	policy := kademlia.DefaultRetryPolicy
+	policy.MaxAttempts = 0
+
+	resolver := kademlia.NewResolver(time.Minute)
+	var addr *net.UDPAddr
+	err := kademlia.Retry(context.Background(), policy, func() (err error) {
+		if addr, err = resolver.Resolve(address); err != nil {
+			log.Printf("bootstrap node %s doesn't resolve yet, retrying: %v", address, err)
+		}
+		return err
+	})
+	return addr, err
+}
+
+// localIP returns the first non-loopback IPv4 address of the host,
+// which is the container's address on the docker network, or the
+// first global IPv6 address on an IPv6-only network
+func localIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "127.0.0.1"
+	}
+	ipv6 := ""
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP.String()
+		}
+		if ipv6 == "" && ipnet.IP.IsGlobalUnicast() {
+			ipv6 = ipnet.IP.String()
+		}
+	}
+	if ipv6 != "" {
+		return ipv6
+	}
+	return "127.0.0.1"
 }
@@ -0,0 +1,190 @@
+package gossip
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ConvergenceReport summarizes how gossip spread through the network
+type ConvergenceReport struct {
+	Nodes               int                  `json:"nodes"`
+	Messages            []MessageConvergence `json:"messages"`
+	RedundantDeliveries map[int]int          `json:"redundantDeliveries"` // node id -> duplicate deliveries
+	HopHistogram        map[int]int          `json:"hopHistogram"`        // hops from origin -> deliveries
+}
+
+// MessageConvergence describes the spread of a single message, times are
+// milliseconds since the message was created and -1 if never reached
+type MessageConvergence struct {
+	MessageID string  `json:"messageId"`
+	Origin    int     `json:"origin"`
+	Reached   int     `json:"reached"` // nodes that have the message, origin included
+	Reach     float64 `json:"reach"`
+	TimeTo50  float64 `json:"timeTo50Ms"`
+	TimeTo90  float64 `json:"timeTo90Ms"`
+	TimeTo99  float64 `json:"timeTo99Ms"`
+}
+
+// GenerateConvergenceReport analyzes the message traces logged so far
+func (nb *NetworkBuilder) GenerateConvergenceReport() ConvergenceReport {
+	nb.traceMu.Lock()
+	traces := make([]MessageTrace, len(nb.traces))
+	copy(traces, nb.traces)
+	nb.traceMu.Unlock()
+
+	sort.SliceStable(traces, func(i, j int) bool {
+		return traces[i].Timestamp.Before(traces[j].Timestamp)
+	})
+
+	report := ConvergenceReport{
+		Nodes:               len(nb.nodes),
+		Messages:            make([]MessageConvergence, 0),
+		RedundantDeliveries: make(map[int]int),
+		HopHistogram:        make(map[int]int),
+	}
+
+	// when each message was created, by its origin
+	created := make(map[string]time.Time)
+	for _, node := range nb.nodes {
+		node.mu.RLock()
+		for _, msg := range node.originated {
+			created[msg.ID] = msg.Timestamp
+		}
+		node.mu.RUnlock()
+		report.RedundantDeliveries[node.GetID()] = node.GetDuplicateCount()
+	}
+
+	// group deliveries per message, in order of arrival
+	order := make([]string, 0)
+	deliveries := make(map[string][]MessageTrace)
+	for _, trace := range traces {
+		if _, exists := deliveries[trace.MessageID]; !exists {
+			order = append(order, trace.MessageID)
+		}
+		deliveries[trace.MessageID] = append(deliveries[trace.MessageID], trace)
+	}
+
+	for _, msgid := range order {
+		msgtraces := deliveries[msgid]
+		start, known := created[msgid]
+		if !known {
+			start = msgtraces[0].Timestamp
+		}
+
+		// hops follow the forwarding chain back to the origin
+		hops := map[int]int{msgtraces[0].OriginalSender: 0}
+		for _, trace := range msgtraces {
+			hop := hops[trace.ImmediateForwarder] + 1
+			hops[trace.Receiver] = hop
+			report.HopHistogram[hop]++
+		}
+
+		convergence := MessageConvergence{
+			MessageID: msgid,
+			Origin:    msgtraces[0].OriginalSender,
+			Reached:   len(msgtraces) + 1,
+		}
+		if report.Nodes > 0 {
+			convergence.Reach = float64(convergence.Reached) / float64(report.Nodes)
+		}
+		timeTo := func(fraction float64) float64 {
+			needed := int(math.Ceil(fraction*float64(report.Nodes))) - 1 // origin excluded
+			if needed <= 0 {
+				return 0
+			}
+			if needed > len(msgtraces) {
+				return -1
+			}
+			return float64(msgtraces[needed-1].Timestamp.Sub(start)) / float64(time.Millisecond)
+		}
+		convergence.TimeTo50 = timeTo(0.5)
+		convergence.TimeTo90 = timeTo(0.9)
+		convergence.TimeTo99 = timeTo(0.99)
+		report.Messages = append(report.Messages, convergence)
+	}
+
+	return report
+}
+
+// ExportConvergenceReport writes the convergence report as JSON and as
+// CSV tables to outputDir, next to the visualization data
+func (nb *NetworkBuilder) ExportConvergenceReport(outputDir string) error {
+	err := os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	report := nb.GenerateConvergenceReport()
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal convergence report: %v", err)
+	}
+	err = os.WriteFile(fmt.Sprintf("%s/convergence_report.json", outputDir), data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write convergence report: %v", err)
+	}
+
+	messages := [][]string{{"message_id", "origin", "reached", "reach", "time_to_50_ms", "time_to_90_ms", "time_to_99_ms"}}
+	for _, msg := range report.Messages {
+		messages = append(messages, []string{
+			msg.MessageID,
+			strconv.Itoa(msg.Origin),
+			strconv.Itoa(msg.Reached),
+			strconv.FormatFloat(msg.Reach, 'f', 4, 64),
+			strconv.FormatFloat(msg.TimeTo50, 'f', 3, 64),
+			strconv.FormatFloat(msg.TimeTo90, 'f', 3, 64),
+			strconv.FormatFloat(msg.TimeTo99, 'f', 3, 64),
+		})
+	}
+
+	nodes := [][]string{{"node", "redundant_deliveries"}}
+	for _, node := range nb.nodes {
+		nodes = append(nodes, []string{strconv.Itoa(node.GetID()), strconv.Itoa(report.RedundantDeliveries[node.GetID()])})
+	}
+
+	hopcounts := make([]int, 0, len(report.HopHistogram))
+	for hop := range report.HopHistogram {
+		hopcounts = append(hopcounts, hop)
+	}
+	sort.Ints(hopcounts)
+	hops := [][]string{{"hops", "deliveries"}}
+	for _, hop := range hopcounts {
+		hops = append(hops, []string{strconv.Itoa(hop), strconv.Itoa(report.HopHistogram[hop])})
+	}
+
+	tables := map[string][][]string{
+		"convergence_messages.csv": messages,
+		"convergence_nodes.csv":    nodes,
+		"convergence_hops.csv":     hops,
+	}
+	for name, rows := range tables {
+		if err := writeCSV(fmt.Sprintf("%s/%s", outputDir, name), rows); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Exported convergence report to %s\n", outputDir)
+	return nil
+}
+
+// writeCSV writes rows to filename
+func writeCSV(filename string, rows [][]string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", filename, err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if err := writer.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write %s: %v", filename, err)
+	}
+	return nil
+}
@@ -2,7 +2,6 @@ package gossip
 
 import (
 	"fmt"
-	mathrand "math/rand"
 	"sync"
 	"time"
 )
@@ -14,15 +13,61 @@ type NetworkBuilder struct {
 	traces  []MessageTrace
 	startTime time.Time
 	traceMu sync.Mutex
+
+	// deterministic simulation, see SetSeed
+	seed int64
+	rng  *Random
+
+	// region of every node id, see AssignRegions
+	regions map[int]string
 }
 
 func NewNetworkBuilder(network Network) *NetworkBuilder {
+	seed := newSeed()
 	return &NetworkBuilder{
 		network: network,
 		nodes:   make([]*GossipNode, 0),
 		traces:  make([]MessageTrace, 0),
 		startTime: time.Now(),
+		seed:    seed,
+		rng:     NewRandom(seed),
+		regions: make(map[int]string),
+	}
+}
+
+// SetSeed makes the run reproducible: the topology, the starting node,
+// the node placement, every node's choices and the network's latency and
+// loss are all drawn from seed. Call it before CreateNodes. Goroutine
+// scheduling still varies between runs, so timing sensitive results can
+// differ slightly even with the same seed
+func (nb *NetworkBuilder) SetSeed(seed int64) {
+	nb.seed = seed
+	nb.rng = NewRandom(seed)
+	if network, ok := nb.simulated(); ok {
+		network.SetSeed(seed)
 	}
+	for _, node := range nb.nodes {
+		node.SetSeed(nb.nodeSeed(node.id))
+	}
+}
+
+// simulated returns the network as a SimulatedNetwork,
+// ok is false for a real network
+func (nb *NetworkBuilder) simulated() (network SimulatedNetwork, ok bool) {
+	network, ok = nb.network.(SimulatedNetwork)
+	return network, ok
+}
+
+// Seed returns the seed of the run, print it when a test fails so
+// the run can be replayed with SetSeed
+func (nb *NetworkBuilder) Seed() int64 {
+	return nb.seed
+}
+
+// nodeSeed returns the seed of node id, derived from the seed of
+// the run so that every node makes its own choices
+func (nb *NetworkBuilder) nodeSeed(id int) int64 {
+	return nb.seed + int64(id) + 1
 }
 
 // CreateNodes creates the specified number of gossip nodes
@@ -34,6 +79,7 @@ func (nb *NetworkBuilder) CreateNodes(count int) error {
 		if err != nil {
 			return fmt.Errorf("failed to create node %d: %v", i, err)
 		}
+		node.SetSeed(nb.nodeSeed(i))
 		nb.nodes = append(nb.nodes, node)
 	}
 
@@ -60,7 +106,7 @@ func (nb *NetworkBuilder) SelectRandomPeers(nodeid int, count int) []int {
 	maxattempts := count * 3 // prevent infinite loop
 
 	for len(peers) < count && maxattempts > 0 {
-		candidate := mathrand.Intn(len(nb.nodes))
+		candidate := nb.rng.Intn(len(nb.nodes))
 		if candidate == nodeid {
 			maxattempts--
 			continue // don't add ourselves
@@ -84,6 +130,80 @@ func (nb *NetworkBuilder) SelectRandomPeers(nodeid int, count int) []int {
 	return peers
 }
 
+// AssignRegions splits the nodes into one contiguous group per region,
+// of about equal size, and tells a SimulatedNetwork which region each
+// node is in. Without regions it does nothing
+func (nb *NetworkBuilder) AssignRegions(regions ...string) {
+	if len(regions) == 0 {
+		return
+	}
+	network, simulated := nb.simulated()
+	for i, node := range nb.nodes {
+		region := regions[i*len(regions)/len(nb.nodes)]
+		nb.regions[node.id] = region
+		if simulated {
+			network.SetRegion(node.addr, region)
+		}
+	}
+}
+
+// BuildRegionalTopology connects every node to intra random peers in its
+// own region and inter random peers in other regions, call AssignRegions first
+func (nb *NetworkBuilder) BuildRegionalTopology(intra, inter int) {
+	fmt.Printf("building regional topology (%d peers in the region, %d outside per node)...\n", intra, inter)
+
+	for _, node := range nb.nodes {
+		inside, outside := make([]int, 0), make([]int, 0)
+		for _, other := range nb.nodes {
+			switch {
+			case other.id == node.id:
+			case nb.regions[other.id] == nb.regions[node.id]:
+				inside = append(inside, other.id)
+			default:
+				outside = append(outside, other.id)
+			}
+		}
+		for _, peerid := range append(nb.pickRandom(inside, intra), nb.pickRandom(outside, inter)...) {
+			node.AddPeer(Address{IP: "127.0.0.1", Port: 8000 + peerid})
+		}
+	}
+}
+
+// pickRandom returns count random ids of candidates, or all of them if
+// there are not that many
+func (nb *NetworkBuilder) pickRandom(candidates []int, count int) []int {
+	nb.rng.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if count < len(candidates) {
+		return candidates[:count]
+	}
+	return candidates
+}
+
+// SetRegionLatency sets the latency and loss of messages between regions
+// a and b in both directions, a == b sets them within a region. It does
+// nothing unless the network is a SimulatedNetwork
+func (nb *NetworkBuilder) SetRegionLatency(a, b string, latency LatencyDistribution, loss float64) {
+	if network, ok := nb.simulated(); ok {
+		network.SetRegionLink(a, b, latency, loss)
+		network.SetRegionLink(b, a, latency, loss)
+	}
+}
+
+// SetBandwidth limits every node to sending send and receiving recv
+// bytes per second, see SimulatedNetwork.SetBandwidth. It does nothing
+// unless the network is a SimulatedNetwork
+func (nb *NetworkBuilder) SetBandwidth(send, recv int) {
+	network, ok := nb.simulated()
+	if !ok {
+		return
+	}
+	for _, node := range nb.nodes {
+		network.SetBandwidth(node.addr, send, recv)
+	}
+}
+
 // StartAllNodes starts all nodes in the network
 func (nb *NetworkBuilder) StartAllNodes() {
 	fmt.Printf("starting %d nodes...\n", len(nb.nodes))
@@ -103,10 +223,121 @@ func (nb *NetworkBuilder) InitiateGossip(content string) {
 	}
 
 	// pick a random node to start the gossip
-	starter := mathrand.Intn(len(nb.nodes))
+	starter := nb.rng.Intn(len(nb.nodes))
 	nb.nodes[starter].Gossip(content)
 }
 
+// SetFanout sets the push fanout of every node, see GossipNode.SetFanout
+func (nb *NetworkBuilder) SetFanout(f int) {
+	for _, node := range nb.nodes {
+		node.SetFanout(f)
+	}
+}
+
+// StartRumorMongering switches every node to rumor mongering,
+// see GossipNode.StartRumorMongering
+func (nb *NetworkBuilder) StartRumorMongering(interval time.Duration, k int) {
+	for _, node := range nb.nodes {
+		node.StartRumorMongering(interval, k)
+	}
+}
+
+// StartAntiEntropy starts anti-entropy on every node, see
+// GossipNode.StartAntiEntropy
+func (nb *NetworkBuilder) StartAntiEntropy(interval time.Duration) {
+	for _, node := range nb.nodes {
+		node.StartAntiEntropy(interval)
+	}
+}
+
+// Chaos returns a Chaos controller for all nodes, seeded from the seed
+// of the builder. It panics unless the network is a SimulatedNetwork,
+// failures can't be injected into a real one
+func (nb *NetworkBuilder) Chaos() *Chaos {
+	network, ok := nb.simulated()
+	if !ok {
+		panic("gossip: chaos needs a SimulatedNetwork")
+	}
+	addrs := make([]Address, len(nb.nodes))
+	for i, node := range nb.nodes {
+		addrs[i] = node.addr
+	}
+	return NewChaos(network, addrs, nb.rng.Int63())
+}
+
+// StartFailureDetector starts the failure detector of every node,
+// see GossipNode.StartFailureDetector
+func (nb *NetworkBuilder) StartFailureDetector(interval, suspectTimeout time.Duration) {
+	for _, node := range nb.nodes {
+		node.StartFailureDetector(interval, suspectTimeout)
+	}
+}
+
+// PlaceNodesRandomly gives every node a random coordinate on a
+// size x size plane, used by the geographic latency of a SimulatedNetwork.
+// It does nothing unless the network is one
+func (nb *NetworkBuilder) PlaceNodesRandomly(size float64) {
+	network, ok := nb.simulated()
+	if !ok {
+		return
+	}
+	for _, node := range nb.nodes {
+		network.SetCoordinates(node.addr, Coordinate{X: nb.rng.Float64() * size, Y: nb.rng.Float64() * size})
+	}
+}
+
+// GossipStats summarizes how far gossip reached and what it cost
+type GossipStats struct {
+	Nodes        int     // nodes in the network
+	Reached      int     // nodes that received at least one message
+	Reach        float64 // fraction of nodes reached
+	MessagesSent int     // messages sent by all nodes
+	Overhead     float64 // messages sent per reached node
+	Spreading    int     // nodes still spreading a rumor
+}
+
+// GetGossipStats returns the reach and overhead of gossip so far
+func (nb *NetworkBuilder) GetGossipStats() GossipStats {
+	return gossipStats(nb.nodes)
+}
+
+// GetRegionStats returns the GossipStats of the nodes of every region
+func (nb *NetworkBuilder) GetRegionStats() map[string]GossipStats {
+	members := make(map[string][]*GossipNode)
+	for _, node := range nb.nodes {
+		if region, exists := nb.regions[node.id]; exists {
+			members[region] = append(members[region], node)
+		}
+	}
+	stats := make(map[string]GossipStats)
+	for region, nodes := range members {
+		stats[region] = gossipStats(nodes)
+	}
+	return stats
+}
+
+// gossipStats returns the reach and overhead of gossip among nodes
+func gossipStats(nodes []*GossipNode) GossipStats {
+	stats := GossipStats{Nodes: len(nodes)}
+	for _, node := range nodes {
+		_, received, sent, _ := node.GetStats()
+		if received > 0 {
+			stats.Reached++
+		}
+		stats.MessagesSent += sent
+		if node.ActiveRumors() > 0 {
+			stats.Spreading++
+		}
+	}
+	if stats.Nodes > 0 {
+		stats.Reach = float64(stats.Reached) / float64(stats.Nodes)
+	}
+	if stats.Reached > 0 {
+		stats.Overhead = float64(stats.MessagesSent) / float64(stats.Reached)
+	}
+	return stats
+}
+
 // GetNodes returns all nodes in the network
 func (nb *NetworkBuilder) GetNodes() []*GossipNode {
 	return nb.nodes
@@ -0,0 +1,277 @@
+package gossip
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Chaos actions, the kinds of ChaosEvent
+const (
+	ChaosDrop      = "drop"
+	ChaosDelay     = "delay"
+	ChaosUndelay   = "undelay"
+	ChaosPartition = "partition"
+	ChaosHeal      = "heal"
+	ChaosCrash     = "crash"
+	ChaosRestart   = "restart"
+)
+
+// ChaosEvent is one thing a Chaos controller did to the network
+type ChaosEvent struct {
+	Time   time.Time
+	Action string
+	Nodes  []Address // the nodes it was done to, in the order they were picked
+	Detail string
+}
+
+func (e ChaosEvent) String() string {
+	addrs := make([]string, len(e.Nodes))
+	for i, addr := range e.Nodes {
+		addrs[i] = addr.String()
+	}
+	return fmt.Sprintf("%s %v %s", e.Action, addrs, e.Detail)
+}
+
+// Chaos injects failures into a network from a test: packet loss, slow
+// nodes, partitions of random nodes and crashes. Random picks are drawn
+// from a seed, so a run can be replayed, and everything that happens is
+// recorded in an event log the test can assert against.
+//
+// A crashed node is cut off from all other nodes, to them it is down,
+// but it keeps its state when it restarts, like a node restarting from
+// disk. Chaos keeps count of why a link is cut, so healing a partition
+// does not revive a crashed node and the other way round, and likewise
+// restores the latency a link had before it was first delayed
+type Chaos struct {
+	network SimulatedNetwork
+	nodes   []Address
+	rng     *Random
+
+	mu      sync.Mutex
+	events  []ChaosEvent
+	cuts    map[link]int // reasons a link is cut
+	delays  map[link]*delayedLink
+	crashed map[Address]bool
+	pending sync.WaitGroup // heals and restarts still to come
+}
+
+// NewChaos returns a Chaos controller for the nodes at addrs on network,
+// drawing its random picks from seed
+func NewChaos(network SimulatedNetwork, addrs []Address, seed int64) *Chaos {
+	nodes := make([]Address, len(addrs))
+	copy(nodes, addrs)
+	return &Chaos{
+		network: network,
+		nodes:   nodes,
+		rng:     NewRandom(seed),
+		cuts:    make(map[link]int),
+		delays:  make(map[link]*delayedLink),
+		crashed: make(map[Address]bool),
+	}
+}
+
+// DropPackets makes the network lose a fraction p of all messages,
+// 0 stops dropping
+func (c *Chaos) DropPackets(p float64) {
+	c.network.SetLossRate(p)
+	c.record(ChaosDrop, nil, fmt.Sprintf("%.0f%% of packets", p*100))
+}
+
+// DelayRandomNode delays every message to and from a random node by
+// delay for duration, and returns the node. Afterwards its links get
+// back the latency they had before
+func (c *Chaos) DelayRandomNode(delay, duration time.Duration) Address {
+	target := c.pick(1)[0]
+	var links []link
+	for _, other := range c.nodes {
+		if other != target {
+			links = append(links, link{target, other}, link{other, target})
+		}
+	}
+
+	c.delay(links, delay)
+	c.record(ChaosDelay, []Address{target}, delay.String()+" for "+duration.String())
+	c.after(duration, func() {
+		c.undelay(links)
+		c.record(ChaosUndelay, []Address{target}, "")
+	})
+	return target
+}
+
+// PartitionRandom cuts size random nodes off from the rest for duration,
+// and returns the nodes
+func (c *Chaos) PartitionRandom(size int, duration time.Duration) []Address {
+	group := c.pick(size)
+	inside := make(map[Address]bool)
+	for _, addr := range group {
+		inside[addr] = true
+	}
+	var links []link
+	for _, a := range group {
+		for _, b := range c.nodes {
+			if !inside[b] {
+				links = append(links, link{a, b}, link{b, a})
+			}
+		}
+	}
+
+	c.cut(links)
+	c.record(ChaosPartition, group, "for "+duration.String())
+	c.after(duration, func() {
+		c.heal(links)
+		c.record(ChaosHeal, group, "")
+	})
+	return group
+}
+
+// CrashRandom crashes a random node that is up and restarts it after
+// downtime, and returns the node. If all nodes are down it does nothing
+// and returns the zero Address
+func (c *Chaos) CrashRandom(downtime time.Duration) Address {
+	c.mu.Lock()
+	up := make([]Address, 0, len(c.nodes))
+	for _, addr := range c.nodes {
+		if !c.crashed[addr] {
+			up = append(up, addr)
+		}
+	}
+	if len(up) == 0 {
+		c.mu.Unlock()
+		return Address{}
+	}
+	target := up[c.rng.Intn(len(up))]
+	c.crashed[target] = true
+	c.mu.Unlock()
+
+	var links []link
+	for _, other := range c.nodes {
+		if other != target {
+			links = append(links, link{target, other}, link{other, target})
+		}
+	}
+
+	c.cut(links)
+	c.record(ChaosCrash, []Address{target}, "for "+downtime.String())
+	c.after(downtime, func() {
+		c.mu.Lock()
+		delete(c.crashed, target)
+		c.mu.Unlock()
+		c.heal(links)
+		c.record(ChaosRestart, []Address{target}, "")
+	})
+	return target
+}
+
+// Wait blocks until every delay is over, every partition is healed
+// and every crashed node is restarted
+func (c *Chaos) Wait() {
+	c.pending.Wait()
+}
+
+// Events returns what the controller did so far, in order
+func (c *Chaos) Events() []ChaosEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	events := make([]ChaosEvent, len(c.events))
+	copy(events, c.events)
+	return events
+}
+
+// pick returns count distinct random nodes, or all of them
+// if there are not that many
+func (c *Chaos) pick(count int) []Address {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	nodes := make([]Address, len(c.nodes))
+	copy(nodes, c.nodes)
+	c.rng.Shuffle(len(nodes), func(i, j int) {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	})
+	if count < len(nodes) {
+		nodes = nodes[:count]
+	}
+	return nodes
+}
+
+// cut cuts links, counting one more reason for each to be cut
+func (c *Chaos) cut(links []link) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range links {
+		if c.cuts[l] == 0 {
+			c.network.CutLink(l.from, l.to)
+		}
+		c.cuts[l]++
+	}
+}
+
+// heal removes one reason for each of links to be cut, and heals
+// the links that have none left
+func (c *Chaos) heal(links []link) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range links {
+		c.cuts[l]--
+		if c.cuts[l] == 0 {
+			delete(c.cuts, l)
+			c.network.HealLink(l.from, l.to)
+		}
+	}
+}
+
+// delayedLink is the latency a link had before Chaos first delayed it,
+// and how many delays of it are not over yet
+type delayedLink struct {
+	previous LatencyDistribution
+	count    int
+}
+
+// delay sets the latency of links to delay, remembering the latency
+// of the links that were not delayed yet
+func (c *Chaos) delay(links []link, delay time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range links {
+		delayed, exists := c.delays[l]
+		if !exists {
+			delayed = &delayedLink{previous: c.network.LinkLatency(l.from, l.to)}
+			c.delays[l] = delayed
+		}
+		delayed.count++
+		c.network.SetLinkLatency(l.from, l.to, FixedLatency(delay))
+	}
+}
+
+// undelay ends one delay of each of links, and restores the latency
+// of the links that have none left
+func (c *Chaos) undelay(links []link) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range links {
+		delayed := c.delays[l]
+		if delayed.count--; delayed.count == 0 {
+			delete(c.delays, l)
+			c.network.SetLinkLatency(l.from, l.to, delayed.previous)
+		}
+	}
+}
+
+// after runs undo once d has passed
+func (c *Chaos) after(d time.Duration, undo func()) {
+	c.pending.Add(1)
+	time.AfterFunc(d, func() {
+		defer c.pending.Done()
+		undo()
+	})
+}
+
+// record appends an event to the log and prints it
+func (c *Chaos) record(action string, nodes []Address, detail string) {
+	event := ChaosEvent{Time: time.Now(), Action: action, Nodes: nodes, Detail: detail}
+	fmt.Printf("chaos: %s\n", event)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, event)
+}
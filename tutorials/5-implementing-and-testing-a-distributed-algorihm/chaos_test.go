@@ -0,0 +1,154 @@
+package gossip
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestChaosEventLog(t *testing.T) {
+	network := NewMockNetwork()
+	addrs := []Address{
+		{IP: "127.0.0.1", Port: 8080},
+		{IP: "127.0.0.1", Port: 8081},
+		{IP: "127.0.0.1", Port: 8082},
+		{IP: "127.0.0.1", Port: 8083},
+	}
+	nodes := startNodes(t, network, addrs...)
+	seed := simulationSeed(t)
+	chaos := NewChaos(network, addrs, seed)
+
+	group := chaos.PartitionRandom(2, 150*time.Millisecond)
+	if replayed := NewChaos(network, addrs, seed).pick(2); replayed[0] != group[0] || replayed[1] != group[1] {
+		t.Errorf("Expected the same seed to pick the same nodes, got %v and %v", group, replayed)
+	}
+	crashed := chaos.CrashRandom(50 * time.Millisecond)
+
+	// a node in the partition reaches the other node in it, unless that one crashed
+	var inside, outside *Node
+	for i, node := range nodes {
+		switch addrs[i] {
+		case group[0]:
+			inside = node
+		case group[1]:
+		default:
+			outside = node
+		}
+	}
+	if err := inside.SendString(outside.Address(), "test", "across the partition"); err == nil {
+		t.Error("Expected sending across the partition to fail")
+	}
+	err := inside.SendString(group[1], "test", "within the partition")
+	if crashedInside := crashed == group[0] || crashed == group[1]; crashedInside != (err != nil) {
+		t.Errorf("Expected sending within the partition to fail only with a crashed node, got %v", err)
+	}
+
+	chaos.Wait()
+	for _, node := range nodes {
+		for _, addr := range addrs {
+			if addr == node.Address() {
+				continue
+			}
+			if err := node.SendString(addr, "test", "after chaos"); err != nil {
+				t.Errorf("Expected all links healed, %s -> %s: %v", node.Address().String(), addr.String(), err)
+			}
+		}
+	}
+
+	var actions []string
+	for _, event := range chaos.Events() {
+		actions = append(actions, event.Action)
+	}
+	if fmt.Sprint(actions) != fmt.Sprint([]string{ChaosPartition, ChaosCrash, ChaosRestart, ChaosHeal}) {
+		t.Errorf("Unexpected event log: %v", chaos.Events())
+	}
+}
+
+func TestChaosDelayEnds(t *testing.T) {
+	network := NewMockNetwork()
+	addrs := []Address{{IP: "127.0.0.1", Port: 8080}, {IP: "127.0.0.1", Port: 8081}, {IP: "127.0.0.1", Port: 8082}}
+	network.SetLinkLatency(addrs[0], addrs[1], FixedLatency(5*time.Millisecond))
+	chaos := NewChaos(network, addrs, simulationSeed(t))
+	rng := NewRandom(1)
+
+	first := chaos.DelayRandomNode(time.Second, 50*time.Millisecond)
+	second := chaos.DelayRandomNode(time.Second, 100*time.Millisecond)
+	for _, to := range addrs {
+		if to != first && network.LinkLatency(first, to)(rng) != time.Second {
+			t.Errorf("Expected the link %s -> %s to be delayed", first.String(), to.String())
+		}
+	}
+
+	chaos.Wait()
+	for _, from := range addrs {
+		for _, to := range addrs {
+			latency := network.LinkLatency(from, to)
+			switch {
+			case from == addrs[0] && to == addrs[1]:
+				if latency == nil || latency(rng) != 5*time.Millisecond {
+					t.Errorf("Expected the latency set before the delays back on %s -> %s", from.String(), to.String())
+				}
+			case latency != nil:
+				t.Errorf("Expected no latency left on %s -> %s after delaying %s and %s", from.String(), to.String(), first.String(), second.String())
+			}
+		}
+	}
+}
+
+// TestGossipUnderChaos is the chaos suite: every case scripts failures
+// while a message spreads, and gossip with anti-entropy must still reach
+// every node once the failures are over
+func TestGossipUnderChaos(t *testing.T) {
+	cases := []struct {
+		name   string
+		script func(chaos *Chaos)
+	}{
+		{"packet loss", func(chaos *Chaos) {
+			chaos.DropPackets(0.3)
+		}},
+		{"slow node", func(chaos *Chaos) {
+			chaos.DelayRandomNode(50*time.Millisecond, 300*time.Millisecond)
+		}},
+		{"partition", func(chaos *Chaos) {
+			chaos.PartitionRandom(5, 300*time.Millisecond)
+		}},
+		{"crashes", func(chaos *Chaos) {
+			for i := 0; i < 3; i++ {
+				chaos.CrashRandom(200 * time.Millisecond)
+			}
+		}},
+		{"everything", func(chaos *Chaos) {
+			chaos.DropPackets(0.1)
+			chaos.DelayRandomNode(20*time.Millisecond, 200*time.Millisecond)
+			chaos.PartitionRandom(3, 200*time.Millisecond)
+			chaos.CrashRandom(300 * time.Millisecond)
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := NewNetworkBuilder(NewMockNetwork())
+			builder.SetSeed(simulationSeed(t))
+			if err := builder.CreateNodes(20); err != nil {
+				t.Fatal(err)
+			}
+			defer builder.CloseAllNodes()
+			builder.BuildRandomTopology(5)
+			builder.StartAllNodes()
+			builder.StartAntiEntropy(20 * time.Millisecond)
+
+			chaos := builder.Chaos()
+			tc.script(chaos)
+			builder.InitiateGossip("through the chaos")
+			chaos.Wait()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for builder.GetGossipStats().Reached < 19 && time.Now().Before(deadline) {
+				time.Sleep(20 * time.Millisecond)
+			}
+			if stats := builder.GetGossipStats(); stats.Reached < 19 {
+				t.Errorf("Expected every node to get the message after %v: %+v", chaos.Events(), stats)
+			}
+		})
+	}
+}
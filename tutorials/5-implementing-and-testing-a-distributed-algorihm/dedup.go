@@ -0,0 +1,140 @@
+package gossip
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// DefaultDedupCapacity is how many message ids a node remembers at
+// least, unless changed with SetDedupCapacity. It is plenty for the
+// simulations of the tutorial at about 12 KB per generation
+const DefaultDedupCapacity = 10000
+
+// defaultFalsePositiveRate is the false positive rate of a full generation
+const defaultFalsePositiveRate = 0.01
+
+// seenSet remembers which message ids a node has seen in bounded memory.
+// It is a time-windowed bloom filter of two generations: ids go into the
+// current one, and once it holds capacity ids it becomes the previous one
+// and the old previous one is forgotten. So the last capacity to
+// 2*capacity ids are remembered, and a new id is taken for seen with a
+// small probability, a false positive that drops the message.
+// A generation is allocated when it is first needed, so idle nodes
+// don't pay for it
+type seenSet struct {
+	capacity int
+	bits     uint64 // bits per generation
+	hashes   int
+
+	current, previous    []uint64
+	count, previousCount int // ids in current and previous
+
+	drops                   int
+	estimatedFalsePositives float64
+}
+
+// DedupStats describes the dedup of a node. False positives, new
+// messages dropped as already seen, can't be told apart from real
+// duplicates, so their number is an estimate
+type DedupStats struct {
+	Capacity                    int     // ids remembered at least
+	Remembered                  int     // ids in the filter
+	Drops                       int     // messages dropped as already seen
+	EstimatedFalsePositiveRate  float64 // chance that a new id is taken for seen right now
+	EstimatedFalsePositiveDrops float64 // new messages dropped so far
+}
+
+// newSeenSet returns a seenSet remembering at least capacity ids with
+// falsePositiveRate once a generation is full
+func newSeenSet(capacity int, falsePositiveRate float64) *seenSet {
+	if capacity < 1 {
+		capacity = 1
+	}
+	bits := uint64(math.Ceil(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	hashes := int(math.Round(float64(bits) / float64(capacity) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &seenSet{capacity: capacity, bits: bits, hashes: hashes}
+}
+
+// Add remembers id, returning false if it was seen already
+func (s *seenSet) Add(id string) bool {
+	if s.contains(id) {
+		s.drops++
+		return false
+	}
+
+	// every new id had the current false positive rate of being dropped
+	// instead, so this many new ids were dropped for every one added
+	rate := s.falsePositiveRate()
+	s.estimatedFalsePositives += rate / (1 - rate)
+
+	s.remember(id)
+	return true
+}
+
+// remember sets the bits of id in the current generation, starting a
+// new one first if it is full. Unlike Add it counts no drop
+func (s *seenSet) remember(id string) {
+	if s.current == nil {
+		s.current = make([]uint64, (s.bits+63)/64)
+	}
+	if s.count >= s.capacity {
+		if s.previous == nil {
+			s.previous = make([]uint64, (s.bits+63)/64)
+		}
+		s.previous, s.current = s.current, s.previous
+		for i := range s.current {
+			s.current[i] = 0
+		}
+		s.previousCount, s.count = s.count, 0
+	}
+	s.each(id, func(bit uint64) {
+		s.current[bit/64] |= 1 << (bit % 64)
+	})
+	s.count++
+}
+
+// contains returns true if id was seen, or is a false positive
+func (s *seenSet) contains(id string) bool {
+	// a generation not allocated yet holds nothing
+	inCurrent, inPrevious := s.current != nil, s.previous != nil
+	s.each(id, func(bit uint64) {
+		mask := uint64(1) << (bit % 64)
+		inCurrent = inCurrent && s.current[bit/64]&mask != 0
+		inPrevious = inPrevious && s.previous[bit/64]&mask != 0
+	})
+	return inCurrent || inPrevious
+}
+
+// each calls set with the bits of id, by double hashing
+func (s *seenSet) each(id string, set func(bit uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	for i := 0; i < s.hashes; i++ {
+		set((h1 + uint64(i)*h2) % s.bits)
+	}
+}
+
+// falsePositiveRate returns the chance that a new id is in
+// the current or the previous generation by accident
+func (s *seenSet) falsePositiveRate() float64 {
+	generation := func(count int) float64 {
+		return math.Pow(1-math.Exp(-float64(s.hashes)*float64(count)/float64(s.bits)), float64(s.hashes))
+	}
+	return 1 - (1-generation(s.count))*(1-generation(s.previousCount))
+}
+
+// Stats returns the DedupStats of the set
+func (s *seenSet) Stats() DedupStats {
+	return DedupStats{
+		Capacity:                    s.capacity,
+		Remembered:                  s.count + s.previousCount,
+		Drops:                       s.drops,
+		EstimatedFalsePositiveRate:  s.falsePositiveRate(),
+		EstimatedFalsePositiveDrops: s.estimatedFalsePositives,
+	}
+}
@@ -0,0 +1,96 @@
+package gossip
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDedupIsBoundedWithFewFalsePositives(t *testing.T) {
+	seen := newSeenSet(1000, defaultFalsePositiveRate)
+	if seen.current != nil || seen.previous != nil {
+		t.Fatal("Expected no generation to be allocated before the first id")
+	}
+	size := 2 * int((seen.bits+63)/64)
+
+	// every id is new, so every drop is a false positive
+	falsePositives := 0
+	added := make([]string, 0)
+	const messages = 200000
+	for i := 0; i < messages; i++ {
+		id := fmt.Sprintf("message-%d", i)
+		if !seen.Add(id) {
+			falsePositives++
+			continue
+		}
+		added = append(added, id)
+	}
+	stats := seen.Stats()
+	fmt.Printf("Dedup of %d messages: %d false positives, %.0f estimated\n",
+		messages, falsePositives, stats.EstimatedFalsePositiveDrops)
+
+	if len(seen.current)+len(seen.previous) != size || stats.Remembered > 2*stats.Capacity {
+		t.Errorf("Expected the filter to stay bounded: %+v", stats)
+	}
+	if rate := float64(falsePositives) / messages; rate > 0.03 {
+		t.Errorf("Expected at most 3%% false positives but got %.1f%%", rate*100)
+	}
+	if estimate := stats.EstimatedFalsePositiveDrops; estimate < float64(falsePositives)/2 || estimate > float64(falsePositives)*2 {
+		t.Errorf("Expected the estimate %.0f to be close to the %d false positives", estimate, falsePositives)
+	}
+
+	// the latest capacity ids added are always remembered
+	for _, id := range added[len(added)-1000:] {
+		if seen.Add(id) {
+			t.Fatalf("Expected recent %s to be remembered", id)
+		}
+	}
+}
+
+func TestSetDedupCapacityKeepsKeptMessagesSeen(t *testing.T) {
+	node, err := NewGossipNode(NewMockNetwork(), 0, 8000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	msg := GossipMessage{ID: "message-0", Sender: 1}
+	node.HandleGossipMessage(msg, 1)
+	node.SetDedupCapacity(10)
+	node.HandleGossipMessage(msg, 1)
+
+	if kept := node.GetReceivedMessages(); len(kept) != 1 {
+		t.Errorf("Expected the message to be kept once but got %d copies", len(kept))
+	}
+	if duplicates := node.GetDuplicateCount(); duplicates != 1 {
+		t.Errorf("Expected the second delivery to be a duplicate but got %d duplicates", duplicates)
+	}
+}
+
+func TestReceivedMessagesAreBounded(t *testing.T) {
+	node, err := NewGossipNode(NewMockNetwork(), 0, 8000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	node.SetDedupCapacity(10)
+
+	const messages = 1000
+	for i := 0; i < messages; i++ {
+		node.HandleGossipMessage(GossipMessage{ID: fmt.Sprintf("message-%d", i), Sender: 1}, 1)
+	}
+
+	kept := node.GetReceivedMessages()
+	if len(kept) < 10 || len(kept) >= 20 {
+		t.Errorf("Expected the last 10 to 20 messages to be kept but got %d", len(kept))
+	}
+	if last := kept[len(kept)-1].ID; last != fmt.Sprintf("message-%d", messages-1) {
+		t.Errorf("Expected the latest message to be kept but the last one is %s", last)
+	}
+	if missing := node.MissingFrom(nil); len(missing) != len(kept) {
+		t.Errorf("Expected anti-entropy to offer only the %d kept messages but got %d", len(kept), len(missing))
+	}
+	// a few new messages are dropped as false positives of the small filter
+	if _, received, _, _ := node.GetStats(); received != messages-node.GetDuplicateCount() {
+		t.Errorf("Expected all %d new messages to be counted but got %d", messages-node.GetDuplicateCount(), received)
+	}
+}
@@ -1,24 +1,32 @@
 package gossip
 
 import (
-	"crypto/rand"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
 	"time"
 )
 
+// DefaultTopic is the topic of messages started with Gossip
+const DefaultTopic = "default"
+
 // GossipMessage represents a piece of information spreading through the network
 type GossipMessage struct {
 	ID        string    `json:"id"`        // unique message identifier
-	Content   string    `json:"content"`   // the actual information
+	Topic     string    `json:"topic"`     // what the payload is about, see Subscribe
+	Payload   []byte    `json:"payload"`   // the actual information
 	Sender    int       `json:"sender"`    // original sender node id
 	Timestamp time.Time `json:"timestamp"` // when message was created
 	TTL       int       `json:"ttl"`       // time-to-live (hops remaining)
 }
 
+// TopicHandler is called with every new message of a topic a node subscribed to
+type TopicHandler func(msg GossipMessage)
+
 
 // GossipNode represents a node in the gossip network
 type GossipNode struct {
@@ -26,9 +34,37 @@ type GossipNode struct {
 	addr         Address
 	peers        []Address // known peer addresses
 	node         *Node
-	seenMessages map[string]bool // prevent message loops
-	receivedMsgs []GossipMessage // messages this node has received
+	seen         *seenSet        // prevent message loops, see SetDedupCapacity
+	receivedMsgs []GossipMessage // recent messages this node has received, see keepRecent
+	originated   []GossipMessage // recent messages this node started gossiping
 	mu           sync.RWMutex
+
+	// background rounds (anti-entropy, peer exchange) run until stop is closed
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	// peer exchange, unanswered discover requests per peer
+	peerMisses map[Address]int
+	maxMerge   int
+
+	// number of random peers each message is pushed to, 0 means all peers
+	fanout int
+
+	// source of every random choice of the node, see SetSeed
+	rng *Random
+
+	// handlers of new messages per topic, see Subscribe
+	subscribers map[string][]TopicHandler
+
+	// rumor mongering, 0 pushes messages once on receipt instead.
+	// hot holds the rumors the node is still spreading
+	rumorK int
+	hot    map[string]GossipMessage
+
+	// failure detection, see StartFailureDetector
+	members        map[Address]*memberState
+	incarnation    int
+	suspectTimeout time.Duration
 	
 	// visualization tracking
 	builder      *NetworkBuilder // reference to builder for trace logging
@@ -36,6 +72,7 @@ type GossipNode struct {
 	// statistics
 	messagesSent     int
 	messagesReceived int
+	duplicates       int // deliveries of messages already seen
 }
 
 // NewGossipNode creates a new gossip node
@@ -51,9 +88,15 @@ func NewGossipNode(network Network, id int, port int, builder *NetworkBuilder) (
 		addr:         addr,
 		peers:        make([]Address, 0),
 		node:         node,
-		seenMessages: make(map[string]bool),
+		seen:         newSeenSet(DefaultDedupCapacity, defaultFalsePositiveRate),
 		receivedMsgs: make([]GossipMessage, 0),
 		builder:      builder,
+		stop:         make(chan struct{}),
+		peerMisses:   make(map[Address]int),
+		rng:          NewRandom(newSeed()),
+		subscribers:  make(map[string][]TopicHandler),
+		hot:          make(map[string]GossipMessage),
+		members:      make(map[Address]*memberState),
 	}
 
 	// set up message handlers
@@ -66,7 +109,7 @@ func (gn *GossipNode) SetupHandlers() {
 	// handle gossip messages
 	gn.node.Handle("gossip", func(msg Message) error {
 		var gossipmsg GossipMessage
-		if err := json.Unmarshal(msg.Payload[7:], &gossipmsg); err != nil { // skip "gossip:" prefix
+		if err := json.Unmarshal(msg.Body(), &gossipmsg); err != nil {
 			return fmt.Errorf("failed to unmarshal gossip message: %v", err)
 		}
 
@@ -75,12 +118,70 @@ func (gn *GossipNode) SetupHandlers() {
 		return gn.HandleGossipMessage(gossipmsg, immediateForwarder)
 	})
 
+	// handle anti-entropy digests by sending back what the peer is missing
+	gn.node.Handle("digest", func(msg Message) error {
+		var ids []string
+		if err := json.Unmarshal(msg.Body(), &ids); err != nil {
+			return fmt.Errorf("failed to unmarshal digest: %v", err)
+		}
+		missing := gn.MissingFrom(ids)
+		if len(missing) == 0 {
+			return nil
+		}
+		data, err := json.Marshal(missing)
+		if err != nil {
+			return fmt.Errorf("failed to marshal pulled messages: %v", err)
+		}
+		return gn.node.Send(msg.From, "pull", data)
+	})
+
+	// handle messages pulled from a peer, they are not pushed further
+	gn.node.Handle("pull", func(msg Message) error {
+		var pulled []GossipMessage
+		if err := json.Unmarshal(msg.Body(), &pulled); err != nil {
+			return fmt.Errorf("failed to unmarshal pulled messages: %v", err)
+		}
+		for _, gossipmsg := range pulled {
+			gossipmsg.TTL = 0
+			gn.HandleGossipMessage(gossipmsg, msg.From.Port-8000)
+		}
+		return nil
+	})
+
+	// handle rumors, telling the sender whether the rumor was news
+	gn.node.Handle("rumor", func(msg Message) error {
+		var gossipmsg GossipMessage
+		if err := json.Unmarshal(msg.Body(), &gossipmsg); err != nil {
+			return fmt.Errorf("failed to unmarshal rumor: %v", err)
+		}
+		gn.mu.RLock()
+		known := gn.seen.contains(gossipmsg.ID)
+		gn.mu.RUnlock()
+		gn.HandleGossipMessage(gossipmsg, msg.From.Port-8000)
+		if known {
+			return msg.ReplyString("rumor-ack", "known")
+		}
+		return msg.ReplyString("rumor-ack", "new")
+	})
+
 	// handle peer discovery
 	gn.node.Handle("discover", func(msg Message) error {
 		// send back our peer list
+		gn.mu.RLock()
 		peerdata, _ := json.Marshal(gn.peers)
+		gn.mu.RUnlock()
 		return gn.node.Send(msg.From, "peers", peerdata)
 	})
+
+	// handle peer lists sent in reply to discover
+	gn.node.Handle("peers", func(msg Message) error {
+		var peers []Address
+		if err := json.Unmarshal(msg.Body(), &peers); err != nil {
+			return fmt.Errorf("failed to unmarshal peer list: %v", err)
+		}
+		gn.MergePeers(msg.From, peers)
+		return nil
+	})
 }
 
 // AddPeer adds a peer to this node's peer list
@@ -102,45 +203,104 @@ func (gn *GossipNode) AddPeer(peeraddr Address) {
 	gn.peers = append(gn.peers, peeraddr)
 }
 
+// GetPeers returns the node's current peer list
+func (gn *GossipNode) GetPeers() []Address {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	peers := make([]Address, len(gn.peers))
+	copy(peers, gn.peers)
+	return peers
+}
+
+// RemovePeer removes a peer from this node's peer list
+func (gn *GossipNode) RemovePeer(peeraddr Address) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	for i, existing := range gn.peers {
+		if existing.Port == peeraddr.Port {
+			gn.peers = append(gn.peers[:i], gn.peers[i+1:]...)
+			break
+		}
+	}
+	delete(gn.peerMisses, peeraddr)
+}
+
 // Start begins the node's operation
 func (gn *GossipNode) Start() {
 	gn.node.Start()
 }
 
-// Gossip initiates spreading of a new message
+// Gossip initiates spreading of a new message on DefaultTopic
 func (gn *GossipNode) Gossip(content string) error {
+	return gn.Publish(DefaultTopic, []byte(content))
+}
+
+// Publish initiates spreading of payload on topic
+func (gn *GossipNode) Publish(topic string, payload []byte) error {
 	// create unique message id
 	msgid := gn.GenerateMessageID()
 
 	gossipmsg := GossipMessage{
 		ID:        msgid,
-		Content:   content,
+		Topic:     topic,
+		Payload:   payload,
 		Sender:    gn.id,
 		Timestamp: time.Now(),
 		TTL:       20, // maximum 20 hops
 	}
 
-	fmt.Printf("node %d starting gossip: '%s'\n", gn.id, content)
+	fmt.Printf("node %d starting gossip: '%s'\n", gn.id, payload)
+
+	// the origin knows its own message, so it is a duplicate when it
+	// comes back and the peer sending it back learns the rumor is known
+	gn.mu.Lock()
+	gn.seen.Add(msgid)
+	gn.originated = gn.keepRecent(gn.originated, gossipmsg)
+	if gn.rumorK > 0 {
+		gn.hot[gossipmsg.ID] = gossipmsg
+		gn.mu.Unlock()
+		return nil // spread by the next rumor rounds
+	}
+	gn.mu.Unlock()
 
 	return gn.SpreadGossip(gossipmsg)
 }
 
+// Subscribe calls handler with every message on topic that the node
+// receives for the first time, several handlers can share a topic.
+// Handlers run on the node's receive loop and should return quickly
+func (gn *GossipNode) Subscribe(topic string, handler TopicHandler) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.subscribers[topic] = append(gn.subscribers[topic], handler)
+}
+
 func (gn *GossipNode) HandleGossipMessage(msg GossipMessage, immediateForwarder int) error {
 	gn.mu.Lock()
 
-	// check if we've seen this message before
-	if gn.seenMessages[msg.ID] {
+	// check if we've seen this message before, and mark it as seen
+	if !gn.seen.Add(msg.ID) {
+		gn.duplicates++
 		gn.mu.Unlock()
 		return nil // already processed
 	}
 
-	// mark as seen
-	gn.seenMessages[msg.ID] = true
-	gn.receivedMsgs = append(gn.receivedMsgs, msg)
+	gn.receivedMsgs = gn.keepRecent(gn.receivedMsgs, msg)
 	gn.messagesReceived++
+	handlers := gn.subscribers[msg.Topic]
+	rumor := gn.rumorK > 0
+	if rumor {
+		gn.hot[msg.ID] = msg
+	}
 
 	gn.mu.Unlock()
 
+	for _, handler := range handlers {
+		handler(msg)
+	}
+
 	// Log message trace for visualization
 	if gn.builder != nil {
 		trace := MessageTrace{
@@ -149,7 +309,7 @@ func (gn *GossipNode) HandleGossipMessage(msg GossipMessage, immediateForwarder
 			OriginalSender:    msg.Sender,
 			ImmediateForwarder: immediateForwarder,
 			Receiver:          gn.id,
-			Content:           msg.Content,
+			Content:           string(msg.Payload),
 			TTL:               msg.TTL,
 			IsDirect:          msg.Sender == immediateForwarder,
 		}
@@ -160,14 +320,15 @@ func (gn *GossipNode) HandleGossipMessage(msg GossipMessage, immediateForwarder
 
 	if msg.Sender == immediateForwarder {
 		// Direct from original sender
-		fmt.Printf("node %d received gossip from node %d: '%s'\n", gn.id, msg.Sender, msg.Content)
+		fmt.Printf("node %d received gossip from node %d: '%s'\n", gn.id, msg.Sender, msg.Payload)
 	} else {
 		// Forwarded by intermediate node
-		fmt.Printf("node %d received gossip from node %d (via node %d): '%s'\n", gn.id, msg.Sender, immediateForwarder, msg.Content)
+		fmt.Printf("node %d received gossip from node %d (via node %d): '%s'\n", gn.id, msg.Sender, immediateForwarder, msg.Payload)
 	}
 
-	// decrease ttl and forward if still valid
-	if msg.TTL > 0 {
+	// decrease ttl and forward if still valid, rumors
+	// are spread by the rumor rounds instead
+	if !rumor && msg.TTL > 0 {
 		msg.TTL--
 		go gn.SpreadGossip(msg)
 	}
@@ -175,13 +336,152 @@ func (gn *GossipNode) HandleGossipMessage(msg GossipMessage, immediateForwarder
 	return nil
 }
 
+// SetDedupCapacity makes the node remember at least the last capacity
+// message ids it has seen, in memory that does not grow beyond that.
+// Older ids are forgotten, and a message is dropped as already seen by
+// mistake with a chance of about 1% once capacity ids are remembered,
+// see GetDedupStats. It forgets the ids seen so far except those of the
+// messages it keeps, for the same window, see keepRecent
+func (gn *GossipNode) SetDedupCapacity(capacity int) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.seen = newSeenSet(capacity, defaultFalsePositiveRate)
+	gn.receivedMsgs = lastMessages(gn.receivedMsgs, gn.seen.capacity)
+	gn.originated = lastMessages(gn.originated, gn.seen.capacity)
+	// a kept message that arrives again must still be a duplicate
+	for _, msg := range gn.originated {
+		gn.seen.remember(msg.ID)
+	}
+	for _, msg := range gn.receivedMsgs {
+		gn.seen.remember(msg.ID)
+	}
+}
+
+// keepRecent appends msg to msgs and returns them. Like the seen set it
+// keeps the last capacity to 2*capacity messages of the dedup window,
+// dropping the older half at once so appending stays cheap. The caller
+// must hold the lock
+func (gn *GossipNode) keepRecent(msgs []GossipMessage, msg GossipMessage) []GossipMessage {
+	msgs = append(msgs, msg)
+	if len(msgs) >= 2*gn.seen.capacity {
+		msgs = lastMessages(msgs, gn.seen.capacity)
+	}
+	return msgs
+}
+
+// lastMessages returns a copy of the last count messages of msgs,
+// so the dropped ones can be freed
+func lastMessages(msgs []GossipMessage, count int) []GossipMessage {
+	if len(msgs) <= count {
+		return msgs
+	}
+	return append(make([]GossipMessage, 0, count), msgs[len(msgs)-count:]...)
+}
+
+// GetDedupStats returns how many messages the node dropped as already
+// seen, and an estimate of how many of them it dropped by mistake
+func (gn *GossipNode) GetDedupStats() DedupStats {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+	return gn.seen.Stats()
+}
+
+// SetFanout limits each push to f random peers instead of all peers,
+// f <= 0 floods all peers again
+func (gn *GossipNode) SetFanout(f int) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.fanout = f
+}
+
+// SetSeed makes the random choices of the node, such as which peers to
+// push to and the ids of new messages, repeat for the same seed
+func (gn *GossipNode) SetSeed(seed int64) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	gn.rng = NewRandom(seed)
+}
+
+// StartRumorMongering switches the node from pushing each new message to
+// its peers once to rumor mongering: every interval the node sends each
+// rumor it is still spreading to a random peer, and stops spreading it
+// with probability 1/k whenever that peer already knew the rumor
+func (gn *GossipNode) StartRumorMongering(interval time.Duration, k int) {
+	gn.mu.Lock()
+	gn.rumorK = k
+	gn.mu.Unlock()
+
+	gn.every(interval, func() {
+		gn.RumorRound(interval)
+	})
+}
+
+// RumorRound sends every rumor the node is still spreading to a random
+// peer, waiting up to timeout for the peer to tell whether it was news
+func (gn *GossipNode) RumorRound(timeout time.Duration) {
+	gn.mu.RLock()
+	if len(gn.peers) == 0 || len(gn.hot) == 0 {
+		gn.mu.RUnlock()
+		return
+	}
+	peers := make([]Address, len(gn.peers))
+	copy(peers, gn.peers)
+	rumors := make([]GossipMessage, 0, len(gn.hot))
+	for _, msg := range gn.hot {
+		rumors = append(rumors, msg)
+	}
+	k := gn.rumorK
+	rng := gn.rng
+	gn.mu.RUnlock()
+
+	// map order is random, keep seeded runs reproducible
+	sort.Slice(rumors, func(i, j int) bool { return rumors[i].ID < rumors[j].ID })
+
+	for _, rumor := range rumors {
+		data, err := json.Marshal(rumor)
+		if err != nil {
+			log.Printf("failed to marshal rumor: %v", err)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		reply, err := gn.node.Request(ctx, peers[rng.Intn(len(peers))], "rumor", data)
+		cancel()
+		if err != nil {
+			continue // peer might be down, another one is picked next round
+		}
+
+		gn.mu.Lock()
+		gn.messagesSent++
+		if string(reply.Body()) == "known" && rng.Intn(k) == 0 {
+			delete(gn.hot, rumor.ID)
+		}
+		gn.mu.Unlock()
+	}
+}
+
+// ActiveRumors returns how many rumors the node is still spreading
+func (gn *GossipNode) ActiveRumors() int {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+	return len(gn.hot)
+}
+
 func (gn *GossipNode) SpreadGossip(msg GossipMessage) error {
 	gn.mu.RLock()
 	peers := make([]Address, len(gn.peers))
 	copy(peers, gn.peers)
+	fanout := gn.fanout
+	rng := gn.rng
 	gn.mu.RUnlock()
 
-	// send to all peers
+	// pick fanout random peers, or all peers when flooding
+	if fanout > 0 && fanout < len(peers) {
+		rng.Shuffle(len(peers), func(i, j int) {
+			peers[i], peers[j] = peers[j], peers[i]
+		})
+		peers = peers[:fanout]
+	}
+
 	for _, peeraddr := range peers {
 		go func(addr Address) {
 			data, err := json.Marshal(msg)
@@ -204,9 +504,158 @@ func (gn *GossipNode) SpreadGossip(msg GossipMessage) error {
 	return nil
 }
 
+// StartAntiEntropy starts periodic anti-entropy rounds: every interval
+// the node sends the ids of the messages it still keeps to a random
+// peer, which sends back the recent messages the node is missing
+func (gn *GossipNode) StartAntiEntropy(interval time.Duration) {
+	gn.every(interval, gn.AntiEntropyRound)
+}
+
+// StartPeerExchange starts periodic peer exchange: every interval the node
+// asks a random peer for its peer list and merges at most maxmerge new
+// peers from it. Peers that leave more than maxmisses requests unanswered
+// are dropped
+func (gn *GossipNode) StartPeerExchange(interval time.Duration, maxmerge, maxmisses int) {
+	gn.mu.Lock()
+	gn.maxMerge = maxmerge
+	gn.mu.Unlock()
+
+	gn.every(interval, func() {
+		gn.PeerExchangeRound(maxmisses)
+	})
+}
+
+// PeerExchangeRound sends a discover request to a random peer, dropping
+// it instead if it left more than maxmisses requests unanswered
+func (gn *GossipNode) PeerExchangeRound(maxmisses int) {
+	gn.mu.Lock()
+	if len(gn.peers) == 0 {
+		gn.mu.Unlock()
+		return
+	}
+	peer := gn.peers[gn.rng.Intn(len(gn.peers))]
+	misses := gn.peerMisses[peer]
+	gn.peerMisses[peer]++
+	gn.mu.Unlock()
+
+	if misses >= maxmisses {
+		gn.RemovePeer(peer)
+		return
+	}
+	gn.node.Send(peer, "discover", nil) // a failed send is a miss as well
+}
+
+// MergePeers merges a random subset of the peers that from knows, and
+// resets the misses of from since it answered
+func (gn *GossipNode) MergePeers(from Address, peers []Address) {
+	gn.mu.Lock()
+	gn.peerMisses[from] = 0
+	maxmerge := gn.maxMerge
+	rng := gn.rng
+	gn.mu.Unlock()
+
+	rng.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+
+	merged := 0
+	for _, peeraddr := range peers {
+		if maxmerge > 0 && merged >= maxmerge {
+			break
+		}
+		if gn.hasPeer(peeraddr) || peeraddr.Port == gn.addr.Port {
+			continue
+		}
+		gn.AddPeer(peeraddr)
+		merged++
+	}
+}
+
+// hasPeer returns true if peeraddr is in the peer list
+func (gn *GossipNode) hasPeer(peeraddr Address) bool {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	for _, existing := range gn.peers {
+		if existing.Port == peeraddr.Port {
+			return true
+		}
+	}
+	return false
+}
+
+// every calls round every interval until the node is closed
+func (gn *GossipNode) every(interval time.Duration, round func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-gn.stop:
+				return
+			case <-ticker.C:
+				round()
+			}
+		}
+	}()
+}
+
+// AntiEntropyRound sends a digest of the ids of the messages the node
+// still keeps to a random peer, so the digest is bounded by the dedup
+// window however many messages passed
+func (gn *GossipNode) AntiEntropyRound() {
+	gn.mu.RLock()
+	if len(gn.peers) == 0 {
+		gn.mu.RUnlock()
+		return
+	}
+	peer := gn.peers[gn.rng.Intn(len(gn.peers))]
+	ids := make([]string, 0, len(gn.receivedMsgs)+len(gn.originated))
+	for _, msg := range gn.receivedMsgs {
+		ids = append(ids, msg.ID)
+	}
+	for _, msg := range gn.originated {
+		ids = append(ids, msg.ID)
+	}
+	gn.mu.RUnlock()
+
+	data, err := json.Marshal(ids)
+	if err != nil {
+		log.Printf("failed to marshal digest: %v", err)
+		return
+	}
+	gn.node.Send(peer, "digest", data) // peer might be down, retry next round
+}
+
+// MissingFrom returns the messages the node still keeps
+// whose ids are not in ids
+func (gn *GossipNode) MissingFrom(ids []string) []GossipMessage {
+	have := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		have[id] = true
+	}
+
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+
+	missing := make([]GossipMessage, 0)
+	for _, msgs := range [][]GossipMessage{gn.receivedMsgs, gn.originated} {
+		for _, msg := range msgs {
+			if !have[msg.ID] {
+				missing = append(missing, msg)
+				have[msg.ID] = true
+			}
+		}
+	}
+	return missing
+}
+
 func (gn *GossipNode) GenerateMessageID() string {
 	bytes := make([]byte, 16)
-	rand.Read(bytes)
+	gn.mu.RLock()
+	rng := gn.rng
+	gn.mu.RUnlock()
+	rng.Read(bytes)
 	return hex.EncodeToString(bytes)
 }
 
@@ -215,15 +664,26 @@ func (gn *GossipNode) GetID() int {
 	return gn.id
 }
 
-// GetStats returns node statistics
+// GetStats returns node statistics: the number of peers, of messages
+// received, of messages sent and of new messages received. Received
+// messages are counted from the start, not only the ones still kept
 func (gn *GossipNode) GetStats() (int, int, int, int) {
 	gn.mu.RLock()
 	defer gn.mu.RUnlock()
 
-	return len(gn.peers), len(gn.receivedMsgs), gn.messagesSent, gn.messagesReceived
+	return len(gn.peers), gn.messagesReceived, gn.messagesSent, gn.messagesReceived
 }
 
-// GetReceivedMessages returns all messages this node has received
+// GetDuplicateCount returns how many deliveries of already seen
+// messages this node has dropped
+func (gn *GossipNode) GetDuplicateCount() int {
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+	return gn.duplicates
+}
+
+// GetReceivedMessages returns the messages this node has received
+// within the dedup window, older ones are no longer kept
 func (gn *GossipNode) GetReceivedMessages() []GossipMessage {
 	gn.mu.RLock()
 	defer gn.mu.RUnlock()
@@ -235,6 +695,9 @@ func (gn *GossipNode) GetReceivedMessages() []GossipMessage {
 
 // Close shuts down the node
 func (gn *GossipNode) Close() error {
+	gn.closeOnce.Do(func() {
+		close(gn.stop)
+	})
 	return gn.node.Close()
 }
 
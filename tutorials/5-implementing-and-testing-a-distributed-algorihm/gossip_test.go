@@ -1,7 +1,12 @@
 package gossip
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -61,3 +66,457 @@ func TestGossipProtocol(t *testing.T) {
 
 	builder.CloseAllNodes()
 }
+
+func TestGossipAntiEntropy(t *testing.T) {
+	network := NewMockNetwork()
+	online, _ := NewGossipNode(network, 0, 8000, nil)
+	offline, _ := NewGossipNode(network, 1, 8001, nil)
+	defer online.Close()
+	defer offline.Close()
+	online.AddPeer(offline.addr)
+	offline.AddPeer(online.addr)
+	online.Start()
+	offline.Start()
+
+	// The push never reaches the offline node
+	network.Partition([]Address{offline.addr}, nil)
+	online.Gossip("missed while offline")
+	time.Sleep(50 * time.Millisecond)
+	network.Heal()
+
+	if _, received, _, _ := offline.GetStats(); received != 0 {
+		t.Fatalf("Expected the offline node to miss the push, got %d messages", received)
+	}
+
+	offline.StartAntiEntropy(20 * time.Millisecond)
+	deadline := time.After(time.Second)
+	for {
+		if msgs := offline.GetReceivedMessages(); len(msgs) == 1 {
+			if string(msgs[0].Payload) != "missed while offline" {
+				t.Errorf("Expected the missed message, got '%s'", msgs[0].Payload)
+			}
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Timeout waiting for anti-entropy to pull the missed message")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestGossipFanout(t *testing.T) {
+	network := NewMockNetwork()
+	builder := NewNetworkBuilder(network)
+	builder.SetSeed(simulationSeed(t))
+	if err := builder.CreateNodes(20); err != nil {
+		t.Fatal(err)
+	}
+	builder.BuildRandomTopology(19)
+	builder.SetFanout(3)
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	builder.InitiateGossip("limited fanout")
+	time.Sleep(200 * time.Millisecond)
+
+	// every node pushes at most once per message it handles, plus the
+	// starter's initial push
+	for _, node := range builder.GetNodes() {
+		if _, _, sent, _ := node.GetStats(); sent > 6 {
+			t.Errorf("Node %d sent %d messages, expected at most 6 with fanout 3", node.GetID(), sent)
+		}
+	}
+
+	stats := builder.GetGossipStats()
+	fmt.Printf("Fanout 3: reach %.0f%%, %d messages, %.1f messages per reached node\n",
+		stats.Reach*100, stats.MessagesSent, stats.Overhead)
+	if stats.Reached == 0 || stats.MessagesSent > 6*stats.Nodes {
+		t.Errorf("Unexpected stats with fanout 3: %+v", stats)
+	}
+}
+
+func TestConvergenceReport(t *testing.T) {
+	network := NewMockNetwork()
+	builder := NewNetworkBuilder(network)
+	builder.SetSeed(simulationSeed(t))
+	if err := builder.CreateNodes(50); err != nil {
+		t.Fatal(err)
+	}
+	builder.BuildRandomTopology(4)
+	builder.StartAllNodes()
+	defer builder.CloseAllNodes()
+
+	builder.InitiateGossip("measure me")
+	time.Sleep(300 * time.Millisecond)
+
+	report := builder.GenerateConvergenceReport()
+	if len(report.Messages) != 1 {
+		t.Fatalf("Expected 1 message in the report, got %d", len(report.Messages))
+	}
+	msg := report.Messages[0]
+	if msg.TimeTo50 > msg.TimeTo90 && msg.TimeTo90 != -1 {
+		t.Errorf("Expected 50%% reach before 90%%: %+v", msg)
+	}
+
+	delivered := 0
+	for _, count := range report.HopHistogram {
+		delivered += count
+	}
+	if delivered != msg.Reached-1 {
+		t.Errorf("Expected the hop histogram to cover %d deliveries, got %d", msg.Reached-1, delivered)
+	}
+	if report.HopHistogram[1] == 0 {
+		t.Error("Expected deliveries directly from the origin")
+	}
+
+	if err := builder.ExportConvergenceReport(t.TempDir()); err != nil {
+		t.Errorf("Failed to export convergence report: %v", err)
+	}
+}
+
+func TestGossipPeerExchange(t *testing.T) {
+	network := NewMockNetwork()
+	nodes := make([]*GossipNode, 3)
+	for i := range nodes {
+		nodes[i], _ = NewGossipNode(network, i, 8000+i, nil)
+		defer nodes[i].Close()
+		nodes[i].Start()
+	}
+	a, b, c := nodes[0], nodes[1], nodes[2]
+	a.AddPeer(b.addr)
+	b.AddPeer(c.addr)
+
+	waitFor := func(condition func() bool, description string) {
+		deadline := time.After(2 * time.Second)
+		for !condition() {
+			select {
+			case <-deadline:
+				t.Fatalf("Timeout waiting for: %s", description)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	a.StartPeerExchange(10*time.Millisecond, 5, 2)
+	b.StartPeerExchange(10*time.Millisecond, 5, 2)
+	waitFor(func() bool { return a.hasPeer(c.addr) }, "a learns about c through b")
+
+	c.Close()
+	waitFor(func() bool { return !a.hasPeer(c.addr) && !b.hasPeer(c.addr) }, "c ages out after failing")
+}
+
+func TestSeededRunsAreReproducible(t *testing.T) {
+	seed := simulationSeed(t)
+
+	run := func() ([][]Address, string, []string) {
+		network := NewMockNetwork()
+		builder := NewNetworkBuilder(network)
+		builder.SetSeed(seed)
+		if err := builder.CreateNodes(20); err != nil {
+			t.Fatal(err)
+		}
+		defer builder.CloseAllNodes()
+		builder.BuildRandomTopology(3)
+
+		topology := make([][]Address, 0)
+		for _, node := range builder.GetNodes() {
+			topology = append(topology, node.GetPeers())
+		}
+
+		// which messages a lossy network delivers depends on the seed only
+		network.SetLossRate(0.5)
+		alice, bob := builder.GetNodes()[0].node, builder.GetNodes()[1].node
+		in := watch(bob, "ping", nil)
+		alice.Start()
+		bob.Start()
+		for i := 0; i < 50; i++ {
+			alice.Send(bob.Address(), "ping", []byte(fmt.Sprint(i)))
+		}
+		time.Sleep(50 * time.Millisecond)
+		delivered := make([]string, 0)
+		for len(in) > 0 {
+			delivered = append(delivered, content(<-in))
+		}
+		return topology, builder.GetNodes()[2].GenerateMessageID(), delivered
+	}
+
+	topology, id, delivered := run()
+	replayedTopology, replayedID, replayedDelivered := run()
+	if fmt.Sprint(topology) != fmt.Sprint(replayedTopology) {
+		t.Error("Expected the same topology for the same seed")
+	}
+	if id != replayedID {
+		t.Errorf("Expected the same message id for the same seed but got %s and %s", id, replayedID)
+	}
+	if fmt.Sprint(delivered) != fmt.Sprint(replayedDelivered) {
+		t.Errorf("Expected the same messages to be lost for the same seed but got %v and %v", delivered, replayedDelivered)
+	}
+	if len(delivered) == 0 || len(delivered) == 50 {
+		t.Errorf("Expected about half of the messages to be lost but %d of 50 arrived", len(delivered))
+	}
+}
+
+func TestGossipTopics(t *testing.T) {
+	network := NewMockNetwork()
+	nodes := make([]*GossipNode, 3)
+	for i := range nodes {
+		nodes[i], _ = NewGossipNode(network, i, 8000+i, nil)
+		defer nodes[i].Close()
+		nodes[i].Start()
+	}
+	nodes[0].AddPeer(nodes[1].addr)
+	nodes[1].AddPeer(nodes[2].addr)
+
+	blocks := make(chan GossipMessage, 10)
+	txs := make(chan GossipMessage, 10)
+	nodes[2].Subscribe("blocks", func(msg GossipMessage) { blocks <- msg })
+	nodes[2].Subscribe("txs", func(msg GossipMessage) { txs <- msg })
+
+	// binary payloads survive, including bytes that look like a type prefix
+	payload := []byte{0x00, 'g', 'o', 's', 's', 'i', 'p', ':', 0xff}
+	nodes[0].Publish("blocks", payload)
+
+	select {
+	case msg := <-blocks:
+		if !bytes.Equal(msg.Payload, payload) || msg.Topic != "blocks" || msg.Sender != 0 {
+			t.Errorf("Unexpected message %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the block two hops away")
+	}
+	select {
+	case msg := <-txs:
+		t.Errorf("Unexpected message on txs: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOriginKnowsItsMessage(t *testing.T) {
+	network := NewMockNetwork()
+	origin, err := NewGossipNode(network, 0, 8000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+	peer, err := NewGossipNode(network, 1, 8001, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	origin.AddPeer(peer.addr)
+	peer.AddPeer(origin.addr)
+	origin.Start()
+	peer.Start()
+
+	// the peer floods the message straight back to its origin
+	origin.Gossip("echo")
+	time.Sleep(100 * time.Millisecond)
+	if received := origin.GetReceivedMessages(); len(received) != 0 {
+		t.Errorf("Expected the origin not to receive its own message, got %v", received)
+	}
+	if origin.GetDuplicateCount() == 0 {
+		t.Error("Expected the echo to count as a duplicate")
+	}
+
+	origin.mu.RLock()
+	data, _ := json.Marshal(origin.originated[0])
+	origin.mu.RUnlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := peer.node.Request(ctx, origin.addr, "rumor", data)
+	if err != nil {
+		t.Fatalf("Failed to send the rumor back: %v", err)
+	}
+	if string(reply.Body()) != "known" {
+		t.Errorf("Expected the origin to know its own rumor, got %q", reply.Body())
+	}
+}
+
+func TestRumorMongeringVersusFlooding(t *testing.T) {
+	seed := simulationSeed(t)
+
+	run := func(rumor bool) GossipStats {
+		builder := NewNetworkBuilder(NewMockNetwork())
+		builder.SetSeed(seed)
+		if err := builder.CreateNodes(50); err != nil {
+			t.Fatal(err)
+		}
+		defer builder.CloseAllNodes()
+		builder.BuildRandomTopology(4)
+		builder.StartAllNodes()
+		if rumor {
+			builder.StartRumorMongering(5*time.Millisecond, 3)
+		}
+
+		builder.InitiateGossip("compare me")
+		deadline := time.Now().Add(3 * time.Second)
+		time.Sleep(100 * time.Millisecond)
+		for builder.GetGossipStats().Spreading > 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return builder.GetGossipStats()
+	}
+
+	flooding, rumor := run(false), run(true)
+	fmt.Printf("Flooding:        reach %.0f%%, %d messages, %.1f messages per reached node\n",
+		flooding.Reach*100, flooding.MessagesSent, flooding.Overhead)
+	fmt.Printf("Rumor mongering: reach %.0f%%, %d messages, %.1f messages per reached node\n",
+		rumor.Reach*100, rumor.MessagesSent, rumor.Overhead)
+
+	if rumor.Spreading != 0 {
+		t.Errorf("Expected every node to stop spreading the rumor, %d still are", rumor.Spreading)
+	}
+	if rumor.Reach < 0.5 {
+		t.Errorf("Expected the rumor to reach at least half of the nodes: %+v", rumor)
+	}
+}
+
+func TestFailureDetection(t *testing.T) {
+	builder := NewNetworkBuilder(NewMockNetwork())
+	builder.SetSeed(simulationSeed(t))
+	if err := builder.CreateNodes(20); err != nil {
+		t.Fatal(err)
+	}
+	defer builder.CloseAllNodes()
+	builder.BuildRandomTopology(3)
+	builder.StartAllNodes()
+	// suspicions must outlive the few rounds a refutation takes to spread
+	builder.StartFailureDetector(50*time.Millisecond, 500*time.Millisecond)
+	nodes := builder.GetNodes()
+
+	// status returns how many live nodes see addr with each status
+	status := func(addr Address, live []*GossipNode) map[MemberStatus]int {
+		counts := make(map[MemberStatus]int)
+		for _, node := range live {
+			for _, member := range node.Members() {
+				if member.Addr == addr {
+					counts[member.Status]++
+				}
+			}
+		}
+		return counts
+	}
+	waitFor := func(condition func() bool, within time.Duration, description string) time.Duration {
+		start := time.Now()
+		for !condition() {
+			if time.Since(start) > within {
+				t.Fatalf("Timeout waiting for: %s", description)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return time.Since(start)
+	}
+
+	// membership spreads until everyone knows everyone
+	waitFor(func() bool {
+		for _, node := range nodes {
+			if len(node.Members()) != len(nodes)-1 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, "all nodes to know each other")
+
+	killed, live := nodes[:2], nodes[2:]
+	for _, node := range killed {
+		node.Close()
+	}
+	detection := waitFor(func() bool {
+		for _, node := range killed {
+			if status(node.addr, live)[MemberDead] != len(live) {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, "every live node to declare the killed nodes dead")
+	fmt.Printf("Failure detection: %d of %d nodes declared dead by all %d live nodes in %v\n",
+		len(killed), len(nodes), len(live), detection)
+
+	// a live node that was wrongly suspected, because an ack came late,
+	// refutes it, so in the end only the killed nodes stay dead
+	waitFor(func() bool {
+		for _, node := range live {
+			if status(node.addr, live)[MemberDead] != 0 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, "no live node to be declared dead")
+}
+
+func TestExportHTML(t *testing.T) {
+	builder := NewNetworkBuilder(NewMockNetwork())
+	builder.SetSeed(simulationSeed(t))
+	if err := builder.CreateNodes(10); err != nil {
+		t.Fatal(err)
+	}
+	defer builder.CloseAllNodes()
+	builder.BuildRandomTopology(3)
+	builder.StartAllNodes()
+
+	// content that would end the script tag if it were not escaped
+	builder.InitiateGossip("</script><script>alert(1)</script>")
+	time.Sleep(100 * time.Millisecond)
+
+	dir := t.TempDir()
+	if err := builder.ExportHTML(dir); err != nil {
+		t.Fatalf("Failed to export HTML: %v", err)
+	}
+	page, err := os.ReadFile(dir + "/network_visualization.html")
+	if err != nil {
+		t.Fatalf("Failed to read the page: %v", err)
+	}
+
+	html := string(page)
+	if strings.Count(html, "</script>") != 2 {
+		t.Fatal("Expected the trace content to be escaped inside the page")
+	}
+	begin := strings.Index(html, `<script id="data" type="application/json">`)
+	end := strings.Index(html[begin:], "</script>")
+	var data VisualizationData
+	if err := json.Unmarshal([]byte(html[begin+len(`<script id="data" type="application/json">`):begin+end]), &data); err != nil {
+		t.Fatalf("Expected the embedded data to be JSON: %v", err)
+	}
+	if len(data.Topology.Nodes) != 10 || len(data.Traces) == 0 || data.Traces[0].Content != "</script><script>alert(1)</script>" {
+		t.Errorf("Unexpected embedded data: %d nodes, %d traces", len(data.Topology.Nodes), len(data.Traces))
+	}
+}
+
+func TestRegionalTopology(t *testing.T) {
+	builder := NewNetworkBuilder(NewMockNetwork())
+	builder.SetSeed(simulationSeed(t))
+	if err := builder.CreateNodes(20); err != nil {
+		t.Fatal(err)
+	}
+	defer builder.CloseAllNodes()
+	builder.AssignRegions() // no regions, nothing to assign
+	if stats := builder.GetRegionStats(); len(stats) != 0 {
+		t.Fatalf("Expected no regions: %+v", stats)
+	}
+	builder.AssignRegions("dc1", "dc2")
+	builder.BuildRegionalTopology(3, 1)
+	builder.SetRegionLatency("dc1", "dc2", FixedLatency(300*time.Millisecond), 0)
+	builder.StartAllNodes()
+
+	builder.GetNodes()[0].Gossip("from dc1")
+	time.Sleep(150 * time.Millisecond)
+	stats := builder.GetRegionStats()
+	if stats["dc1"].Nodes != 10 || stats["dc2"].Nodes != 10 {
+		t.Fatalf("Expected 10 nodes per region: %+v", stats)
+	}
+	if stats["dc1"].Reached == 0 {
+		t.Errorf("Expected the gossip to spread within dc1 first: %+v", stats["dc1"])
+	}
+	if stats["dc2"].Reached != 0 {
+		t.Errorf("Expected the gossip not to have crossed to dc2 yet: %+v", stats["dc2"])
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for builder.GetRegionStats()["dc2"].Reached == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := builder.GetRegionStats()["dc2"]; stats.Reached == 0 {
+		t.Errorf("Expected the gossip to reach dc2 eventually: %+v", stats)
+	}
+}
@@ -0,0 +1,210 @@
+package gossip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MemberStatus is the liveness of a member as seen by a node
+type MemberStatus int
+
+const (
+	MemberAlive MemberStatus = iota
+	MemberSuspect
+	MemberDead
+)
+
+func (s MemberStatus) String() string {
+	switch s {
+	case MemberAlive:
+		return "alive"
+	case MemberSuspect:
+		return "suspect"
+	case MemberDead:
+		return "dead"
+	}
+	return fmt.Sprintf("MemberStatus(%d)", int(s))
+}
+
+// Member is a node of the network and its liveness. Incarnation is
+// raised by the member itself to refute that it is suspected or dead
+type Member struct {
+	Addr        Address      `json:"addr"`
+	Status      MemberStatus `json:"status"`
+	Incarnation int          `json:"incarnation"`
+}
+
+// overrides returns true if what m says about a member is newer than
+// what other says, following SWIM: a higher incarnation wins, and at the
+// same incarnation dead beats suspect beats alive
+func (m Member) overrides(other Member) bool {
+	if m.Incarnation != other.Incarnation {
+		return m.Incarnation > other.Incarnation
+	}
+	return m.Status > other.Status
+}
+
+// memberState is a Member and when the node started suspecting it
+type memberState struct {
+	Member
+	suspectedAt time.Time
+}
+
+// StartFailureDetector starts a SWIM-lite failure detector: every interval
+// the node pings a random member that is not dead, and suspects it if no
+// ack arrives within the interval. Members suspected for longer than
+// suspectTimeout are declared dead. Pings and acks piggyback the member
+// list of the sender, so suspicions and deaths spread like gossip, and a
+// member that hears it is suspected, or wrongly declared dead, refutes it
+// with a higher incarnation
+func (gn *GossipNode) StartFailureDetector(interval, suspectTimeout time.Duration) {
+	gn.mu.Lock()
+	gn.suspectTimeout = suspectTimeout
+	gn.mu.Unlock()
+
+	gn.node.Handle("swim-ping", func(msg Message) error {
+		var members []Member
+		if err := json.Unmarshal(msg.Body(), &members); err != nil {
+			return fmt.Errorf("failed to unmarshal member list: %v", err)
+		}
+		gn.mergeMembers(members)
+		gn.markAlive(msg.From)
+		data, err := json.Marshal(gn.memberList())
+		if err != nil {
+			return fmt.Errorf("failed to marshal member list: %v", err)
+		}
+		return msg.Reply("swim-ack", data)
+	})
+
+	gn.every(interval, func() {
+		gn.FailureDetectorRound(interval)
+	})
+}
+
+// FailureDetectorRound pings a random member that is not dead, waiting
+// up to timeout for its ack, and declares members dead that have been
+// suspected for too long
+func (gn *GossipNode) FailureDetectorRound(timeout time.Duration) {
+	gn.mu.Lock()
+	now := time.Now()
+	for _, peer := range gn.peers {
+		if _, exists := gn.members[peer]; !exists {
+			gn.members[peer] = &memberState{Member: Member{Addr: peer}}
+		}
+	}
+	targets := make([]Address, 0, len(gn.members))
+	for addr, member := range gn.members {
+		if member.Status == MemberSuspect && now.Sub(member.suspectedAt) > gn.suspectTimeout {
+			member.Status = MemberDead
+		}
+		if member.Status != MemberDead {
+			targets = append(targets, addr)
+		}
+	}
+	rng := gn.rng
+	gn.mu.Unlock()
+
+	if len(targets) == 0 {
+		return
+	}
+	// map order is random, keep seeded runs reproducible
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Port < targets[j].Port })
+	target := targets[rng.Intn(len(targets))]
+
+	data, err := json.Marshal(gn.memberList())
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	reply, err := gn.node.Request(ctx, target, "swim-ping", data)
+	if err != nil {
+		gn.suspect(target)
+		return
+	}
+
+	var members []Member
+	if err := json.Unmarshal(reply.Body(), &members); err == nil {
+		gn.mergeMembers(members)
+	}
+	gn.markAlive(target)
+}
+
+// Members returns the other nodes this node knows of and their
+// liveness, ordered by address
+func (gn *GossipNode) Members() []Member {
+	gn.mu.RLock()
+	members := make([]Member, 0, len(gn.members))
+	for _, member := range gn.members {
+		members = append(members, member.Member)
+	}
+	gn.mu.RUnlock()
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Addr.String() < members[j].Addr.String()
+	})
+	return members
+}
+
+// memberList returns the member list piggybacked on pings and
+// acks, the node itself included
+func (gn *GossipNode) memberList() []Member {
+	members := gn.Members()
+	gn.mu.RLock()
+	defer gn.mu.RUnlock()
+	return append(members, Member{Addr: gn.addr, Incarnation: gn.incarnation})
+}
+
+// mergeMembers applies what another node says about the members
+func (gn *GossipNode) mergeMembers(members []Member) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+
+	now := time.Now()
+	for _, member := range members {
+		if member.Addr == gn.addr {
+			if member.Status != MemberAlive && member.Incarnation >= gn.incarnation {
+				gn.incarnation = member.Incarnation + 1
+			}
+			continue
+		}
+		known, exists := gn.members[member.Addr]
+		if !exists {
+			gn.members[member.Addr] = &memberState{Member: member, suspectedAt: now}
+			continue
+		}
+		if member.overrides(known.Member) {
+			if member.Status == MemberSuspect && known.Status != MemberSuspect {
+				known.suspectedAt = now
+			}
+			known.Member = member
+		}
+	}
+}
+
+// suspect marks addr as suspected after it failed to ack a ping
+func (gn *GossipNode) suspect(addr Address) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	if member, exists := gn.members[addr]; exists && member.Status == MemberAlive {
+		member.Status = MemberSuspect
+		member.suspectedAt = time.Now()
+	}
+}
+
+// markAlive clears the suspicion of addr after hearing from it directly
+func (gn *GossipNode) markAlive(addr Address) {
+	gn.mu.Lock()
+	defer gn.mu.Unlock()
+	member, exists := gn.members[addr]
+	if !exists {
+		gn.members[addr] = &memberState{Member: Member{Addr: addr}}
+		return
+	}
+	if member.Status == MemberSuspect {
+		member.Status = MemberAlive
+	}
+}
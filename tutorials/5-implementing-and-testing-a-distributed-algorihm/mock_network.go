@@ -3,18 +3,94 @@ package gossip
 import (
 	"errors"
 	"sync"
+	"time"
 )
 
 type mockNetwork struct {
-	mu         sync.RWMutex
-	listeners  map[Address]chan Message
-	partitions map[Address]bool // true if the address is partitioned
+	mu          sync.RWMutex
+	listeners   map[Address]chan Message
+	partitions  map[Address]bool // true if the address is partitioned
+	cuts        map[link]bool    // true if the one-directional link is cut
+	latency     LatencyDistribution
+	linkLatency map[link]LatencyDistribution
+	coords      map[Address]Coordinate
+	geoPerUnit  time.Duration // 0 disables geographic latency
+	geoJitter   time.Duration
+	regions     map[Address]string
+	regionLinks map[regionLink]regionLinkConfig
+	globalLoss  float64 // probability that a message is dropped
+	dupRate     float64 // probability that a message is delivered twice
+	rng         *Random
+
+	// store-and-forward, messages for unreachable addresses wait here
+	mailboxes       map[Address][]Message
+	mailboxCapacity int // 0 disables mailboxes
+
+	// bandwidth simulation, queues are changed under queueMu while
+	// holding the read lock
+	bandwidths map[Address]bandwidth
+	queueLimit int // 0 leaves queues unbounded
+	queueMu    sync.Mutex
+	uplinks    map[Address]*linkQueue
+	downlinks  map[Address]*linkQueue
+}
+
+// messageOverhead is the size of the IP and UDP headers added to the
+// payload of every message, in bytes
+const messageOverhead = 28
+
+// bandwidth is how many bytes per second a node sends and receives,
+// 0 is unlimited
+type bandwidth struct {
+	send, recv int
+}
+
+// linkQueue is the queue in front of the uplink or downlink of a node.
+// Messages are transmitted one at a time at the bandwidth of the link, a
+// message is queued until its transmission is done
+type linkQueue struct {
+	done    []time.Time // when each queued message is transmitted, in order
+	longest int
+	drops   int
+}
+
+// QueueStats describes the send and receive queues of a node
+type QueueStats struct {
+	SendQueue, RecvQueue       int // messages queued right now
+	MaxSendQueue, MaxRecvQueue int // longest the queues have been
+	SendDrops, RecvDrops       int // messages dropped because a queue was full
+}
+
+// link is a one-directional connection between two addresses
+type link struct {
+	from, to Address
 }
 
-func NewMockNetwork() Network {
+// regionLink is a one-directional connection between two regions
+type regionLink struct {
+	from, to string
+}
+
+// regionLinkConfig is how messages over a regionLink behave
+type regionLinkConfig struct {
+	latency LatencyDistribution // nil leaves the latency to the other settings
+	loss    float64
+}
+
+func NewMockNetwork() SimulatedNetwork {
 	return &mockNetwork{
-		listeners:  make(map[Address]chan Message),
-		partitions: make(map[Address]bool),
+		listeners:   make(map[Address]chan Message),
+		partitions:  make(map[Address]bool),
+		cuts:        make(map[link]bool),
+		linkLatency: make(map[link]LatencyDistribution),
+		coords:      make(map[Address]Coordinate),
+		regions:     make(map[Address]string),
+		regionLinks: make(map[regionLink]regionLinkConfig),
+		mailboxes:   make(map[Address][]Message),
+		rng:         NewRandom(newSeed()),
+		bandwidths:  make(map[Address]bandwidth),
+		uplinks:     make(map[Address]*linkQueue),
+		downlinks:   make(map[Address]*linkQueue),
 	}
 }
 
@@ -26,13 +102,15 @@ func (n *mockNetwork) Listen(addr Address) (Connection, error) {
 	}
 	ch := make(chan Message, 100) // buffered channel
 	n.listeners[addr] = ch
+	n.flushMailboxes() // a restarted node gets what was sent while it was down
 	return &mockConnection{addr: addr, network: n, recvCh: ch}, nil
 }
 
 func (n *mockNetwork) Dial(addr Address) (Connection, error) {
 	n.mu.RLock()
 	defer n.mu.RUnlock()
-	if _, exists := n.listeners[addr]; !exists {
+	// With mailboxes, sending to a node that is down is queued instead
+	if _, exists := n.listeners[addr]; !exists && n.mailboxCapacity == 0 {
 		return nil, errors.New("address not found")
 	}
 	return &mockConnection{addr: addr, network: n}, nil
@@ -53,6 +131,312 @@ func (n *mockNetwork) Heal() {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 	n.partitions = make(map[Address]bool)
+	n.cuts = make(map[link]bool)
+	n.flushMailboxes()
+}
+
+func (n *mockNetwork) CutLink(from, to Address) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cuts[link{from, to}] = true
+}
+
+func (n *mockNetwork) HealLink(from, to Address) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.cuts, link{from, to})
+	n.flushMailboxes()
+}
+
+func (n *mockNetwork) SchedulePartition(group1, group2 []Address, at, healAt time.Duration) {
+	setLinks := func(cut bool) {
+		for _, a := range group1 {
+			for _, b := range group2 {
+				if cut {
+					n.CutLink(a, b)
+					n.CutLink(b, a)
+				} else {
+					n.HealLink(a, b)
+					n.HealLink(b, a)
+				}
+			}
+		}
+	}
+	time.AfterFunc(at, func() { setLinks(true) })
+	time.AfterFunc(healAt, func() { setLinks(false) })
+}
+
+func (n *mockNetwork) SetLatency(latency LatencyDistribution) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latency = latency
+}
+
+func (n *mockNetwork) SetLinkLatency(from, to Address, latency LatencyDistribution) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if latency == nil {
+		delete(n.linkLatency, link{from, to})
+		return
+	}
+	n.linkLatency[link{from, to}] = latency
+}
+
+func (n *mockNetwork) LinkLatency(from, to Address) LatencyDistribution {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.linkLatency[link{from, to}]
+}
+
+func (n *mockNetwork) SetCoordinates(addr Address, coord Coordinate) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.coords[addr] = coord
+}
+
+func (n *mockNetwork) SetGeoLatency(perUnit, jitter time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.geoPerUnit = perUnit
+	n.geoJitter = jitter
+}
+
+func (n *mockNetwork) SetRegion(addr Address, region string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.regions[addr] = region
+}
+
+func (n *mockNetwork) SetRegionLink(from, to string, latency LatencyDistribution, loss float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.regionLinks[regionLink{from, to}] = regionLinkConfig{latency, loss}
+}
+
+// regionLinkOf returns the configuration of the region link a message
+// from -> to travels over, the caller must hold the lock
+func (n *mockNetwork) regionLinkOf(from, to Address) (regionLinkConfig, bool) {
+	fromRegion, fromExists := n.regions[from]
+	toRegion, toExists := n.regions[to]
+	if !fromExists || !toExists {
+		return regionLinkConfig{}, false
+	}
+	config, exists := n.regionLinks[regionLink{fromRegion, toRegion}]
+	return config, exists
+}
+
+// lossRate returns the probability that a message from -> to
+// is lost, the caller must hold the lock
+func (n *mockNetwork) lossRate(from, to Address) float64 {
+	if config, exists := n.regionLinkOf(from, to); exists {
+		return config.loss
+	}
+	return n.globalLoss
+}
+
+func (n *mockNetwork) SetLossRate(p float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.globalLoss = p
+}
+
+func (n *mockNetwork) SetDuplicateRate(p float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.dupRate = p
+}
+
+func (n *mockNetwork) SetMailbox(capacity int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.mailboxCapacity = capacity
+	if capacity == 0 {
+		n.mailboxes = make(map[Address][]Message)
+	}
+}
+
+func (n *mockNetwork) SetBandwidth(addr Address, send, recv int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.bandwidths[addr] = bandwidth{send, recv}
+}
+
+func (n *mockNetwork) SetQueueLimit(capacity int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.queueLimit = capacity
+}
+
+func (n *mockNetwork) QueueStats(addr Address) QueueStats {
+	n.queueMu.Lock()
+	defer n.queueMu.Unlock()
+
+	now := time.Now()
+	var stats QueueStats
+	if q, exists := n.uplinks[addr]; exists {
+		stats.SendQueue, stats.MaxSendQueue, stats.SendDrops = q.length(now), q.longest, q.drops
+	}
+	if q, exists := n.downlinks[addr]; exists {
+		stats.RecvQueue, stats.MaxRecvQueue, stats.RecvDrops = q.length(now), q.longest, q.drops
+	}
+	return stats
+}
+
+// reserve queues a message of size bytes arriving at the link at at,
+// returning when it is transmitted at rate bytes per second, or false if
+// the queue already holds limit messages and the message is dropped
+func (q *linkQueue) reserve(at time.Time, size, rate, limit int) (time.Time, bool) {
+	// messages transmitted by now don't count for anything arriving later
+	now := time.Now()
+	for len(q.done) > 0 && !q.done[0].After(now) {
+		q.done = q.done[1:]
+	}
+
+	length := q.length(at)
+	if limit > 0 && length >= limit {
+		q.drops++
+		return time.Time{}, false
+	}
+	start := at
+	if len(q.done) > 0 && q.done[len(q.done)-1].After(start) {
+		start = q.done[len(q.done)-1]
+	}
+	done := start.Add(time.Duration(size) * time.Second / time.Duration(rate))
+	q.done = append(q.done, done)
+	if length+1 > q.longest {
+		q.longest = length + 1
+	}
+	return done, true
+}
+
+// length returns how many messages are queued at at
+func (q *linkQueue) length(at time.Time) int {
+	length := 0
+	for _, done := range q.done {
+		if done.After(at) {
+			length++
+		}
+	}
+	return length
+}
+
+// transmit queues a message of size bytes on the link of addr in
+// queues, which arrives there at at, and returns when it is transmitted.
+// Without a bandwidth limit it is transmitted right away. The caller
+// must hold the read lock
+func (n *mockNetwork) transmit(queues map[Address]*linkQueue, addr Address, rate, size int, at time.Time) (time.Time, bool) {
+	if rate <= 0 {
+		return at, true
+	}
+	n.queueMu.Lock()
+	defer n.queueMu.Unlock()
+	q, exists := queues[addr]
+	if !exists {
+		q = &linkQueue{}
+		queues[addr] = q
+	}
+	return q.reserve(at, size, rate, n.queueLimit)
+}
+
+func (n *mockNetwork) SetSeed(seed int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rng = NewRandom(seed)
+}
+
+// reachable returns why a message from -> to can't be delivered right
+// now, or nil if it can. The caller must hold the lock
+func (n *mockNetwork) reachable(from, to Address) error {
+	if n.partitions[to] {
+		return errors.New("network partitioned")
+	}
+	if n.cuts[link{from, to}] {
+		return errors.New("link cut")
+	}
+	if _, exists := n.listeners[to]; !exists {
+		return errors.New("destination address not found")
+	}
+	return nil
+}
+
+// enqueue puts msg in the mailbox of its destination, failing
+// if the mailbox is full
+func (n *mockNetwork) enqueue(msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.mailboxes[msg.To]) >= n.mailboxCapacity {
+		return errors.New("mailbox full")
+	}
+	n.mailboxes[msg.To] = append(n.mailboxes[msg.To], msg)
+	n.flushMailboxes() // the destination may have come back meanwhile
+	return nil
+}
+
+// flushMailboxes delivers the queued messages that are deliverable
+// again, the caller must hold the write lock
+func (n *mockNetwork) flushMailboxes() {
+	for addr, queued := range n.mailboxes {
+		waiting := queued[:0]
+		for _, msg := range queued {
+			if n.reachable(msg.From, addr) != nil {
+				waiting = append(waiting, msg)
+				continue
+			}
+			select {
+			case n.listeners[addr] <- msg:
+			default:
+				waiting = append(waiting, msg) // receive queue full, retry later
+			}
+		}
+		if len(waiting) == 0 {
+			delete(n.mailboxes, addr)
+		} else {
+			n.mailboxes[addr] = waiting
+		}
+	}
+}
+
+// delay returns how long a message from -> to is in flight,
+// the caller must hold the lock
+func (n *mockNetwork) delay(from, to Address) time.Duration {
+	if latency, exists := n.linkLatency[link{from, to}]; exists {
+		return latency(n.rng)
+	}
+	if config, exists := n.regionLinkOf(from, to); exists && config.latency != nil {
+		return config.latency(n.rng)
+	}
+	fromCoord, fromExists := n.coords[from]
+	toCoord, toExists := n.coords[to]
+	if n.geoPerUnit > 0 && fromExists && toExists {
+		d := time.Duration(fromCoord.Distance(toCoord) * float64(n.geoPerUnit))
+		if n.geoJitter > 0 {
+			d += time.Duration(n.rng.Int63n(int64(n.geoJitter) + 1))
+		}
+		return d
+	}
+	if n.latency != nil {
+		return n.latency(n.rng)
+	}
+	return 0
+}
+
+// deliver puts msg in the receive queue of its destination
+func (n *mockNetwork) deliver(msg Message) error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	ch, exists := n.listeners[msg.To]
+	if !exists {
+		return errors.New("destination address not found")
+	}
+
+	// Keep the lock while sending to prevent the channel from being closed
+	select {
+	case ch <- msg:
+		return nil
+	default:
+		return errors.New("message queue full")
+	}
 }
 
 type mockConnection struct {
@@ -64,42 +448,79 @@ type mockConnection struct {
 }
 
 func (c *mockConnection) Send(msg Message) error {
+	// Add network reference to message for replies
+	msg.network = c.network
+
 	c.network.mu.RLock()
 	
-	if c.network.partitions[c.addr] || c.network.partitions[msg.To] {
+	if err := c.network.reachable(msg.From, msg.To); err != nil {
+		mailbox := c.network.mailboxCapacity > 0
 		c.network.mu.RUnlock()
-		return errors.New("network partitioned")
+		if mailbox {
+			return c.network.enqueue(msg)
+		}
+		return err
 	}
-	
-	ch, exists := c.network.listeners[msg.To]
-	if !exists {
+
+	// The message waits for the uplink of the sender, like UDP it is
+	// dropped silently if the send queue is full
+	now := time.Now()
+	size := len(msg.Payload) + messageOverhead
+	sent, ok := c.network.transmit(c.network.uplinks, msg.From, c.network.bandwidths[msg.From].send, size, now)
+	if !ok {
 		c.network.mu.RUnlock()
-		return errors.New("destination address not found")
+		return nil
 	}
-	
-	// Add network reference to message for replies
-	msg.network = c.network
-	
-	// Keep the lock while sending to prevent the channel from being closed
-	select {
-	case ch <- msg:
+
+	// Like UDP, a lost message still looks sent to the sender
+	if c.network.rng.Float64() < c.network.lossRate(msg.From, msg.To) {
 		c.network.mu.RUnlock()
 		return nil
-	default:
-		c.network.mu.RUnlock()
-		return errors.New("message queue full")
 	}
+	copies := 1
+	if c.network.rng.Float64() < c.network.dupRate {
+		copies = 2
+	}
+	delays := make([]time.Duration, 0, copies)
+	for i := 0; i < copies; i++ {
+		// then crosses the network and waits for the downlink of the receiver
+		arrival := sent.Add(c.network.delay(msg.From, msg.To))
+		received, ok := c.network.transmit(c.network.downlinks, msg.To, c.network.bandwidths[msg.To].recv, size, arrival)
+		if ok {
+			delays = append(delays, received.Sub(now))
+		}
+	}
+	c.network.mu.RUnlock()
+
+	var err error
+	for _, delay := range delays {
+		if delay > 0 {
+			// The message is in flight, like UDP it is lost silently
+			// if the destination is gone or full when it arrives
+			go func(delay time.Duration) {
+				time.Sleep(delay)
+				c.network.deliver(msg)
+			}(delay)
+			continue
+		}
+		if deliverErr := c.network.deliver(msg); deliverErr != nil {
+			err = deliverErr
+		}
+	}
+	return err
 }
 
 func (c *mockConnection) Recv() (Message, error) {
 	c.mu.RLock()
-	if c.closed || c.recvCh == nil {
+	if c.recvCh == nil {
 		c.mu.RUnlock()
 		return Message{}, errors.New("connection not listening")
 	}
 	ch := c.recvCh
 	c.mu.RUnlock()
 	
+	// Messages queued before Close are still returned, then the
+	// closed channel reports that the connection is closed
 	msg, ok := <-ch
 	if !ok {
 		return Message{}, errors.New("connection closed")
@@ -122,7 +543,6 @@ func (c *mockConnection) Close() error {
 	if c.recvCh != nil {
 		close(c.recvCh)
 		delete(c.network.listeners, c.addr)
-		c.recvCh = nil
 	}
 	return nil
 }
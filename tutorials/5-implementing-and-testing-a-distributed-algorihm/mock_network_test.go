@@ -0,0 +1,302 @@
+package gossip
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUniformLatencyBounds(t *testing.T) {
+	rng := NewRandom(1)
+	latency := UniformLatency(20*time.Millisecond, 10*time.Millisecond)
+	for i := 0; i < 100; i++ {
+		if d := latency(rng); d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("Expected swapped bounds to give 10-20ms, got %v", d)
+		}
+	}
+}
+
+func TestMockNetworkLatency(t *testing.T) {
+	network := NewMockNetwork()
+	alice, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8081})
+	defer alice.Close()
+	defer bob.Close()
+
+	network.SetLatency(FixedLatency(10 * time.Millisecond))
+	network.SetLinkLatency(alice.Address(), bob.Address(), UniformLatency(100*time.Millisecond, 120*time.Millisecond))
+
+	received := make(chan time.Time, 2)
+	handler := func(msg Message) error {
+		received <- time.Now()
+		return nil
+	}
+	alice.Handle("hello", handler)
+	bob.Handle("hello", handler)
+	alice.Start()
+	bob.Start()
+
+	start := time.Now()
+	if err := alice.SendString(bob.Address(), "hello", "slow link"); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if elapsed := (<-received).Sub(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected at least 100ms on the alice->bob link, got %v", elapsed)
+	}
+
+	start = time.Now()
+	if err := bob.SendString(alice.Address(), "hello", "default link"); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if elapsed := (<-received).Sub(start); elapsed < 10*time.Millisecond || elapsed >= 100*time.Millisecond {
+		t.Errorf("Expected the default 10ms on the bob->alice link, got %v", elapsed)
+	}
+}
+
+func TestMockNetworkLossAndDuplication(t *testing.T) {
+	network := NewMockNetwork()
+	alice, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8081})
+	defer alice.Close()
+	defer bob.Close()
+
+	received := make(chan struct{}, 100)
+	bob.Handle("hello", func(msg Message) error {
+		received <- struct{}{}
+		return nil
+	})
+	bob.Start()
+
+	countReceived := func(sent int) int {
+		for i := 0; i < sent; i++ {
+			alice.SendString(bob.Address(), "hello", "")
+		}
+		time.Sleep(50 * time.Millisecond)
+		count := len(received)
+		for len(received) > 0 {
+			<-received
+		}
+		return count
+	}
+
+	network.SetLossRate(1)
+	if count := countReceived(10); count != 0 {
+		t.Errorf("Expected all messages to be lost, got %d", count)
+	}
+
+	network.SetLossRate(0)
+	network.SetDuplicateRate(1)
+	if count := countReceived(10); count != 20 {
+		t.Errorf("Expected every message twice, got %d", count)
+	}
+}
+
+func TestMockNetworkLinkCuts(t *testing.T) {
+	network := NewMockNetwork()
+	alice, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8081})
+	defer alice.Close()
+	defer bob.Close()
+
+	network.CutLink(alice.Address(), bob.Address())
+	if err := alice.SendString(bob.Address(), "hello", ""); err == nil {
+		t.Error("Expected alice->bob to fail on a cut link")
+	}
+	if err := bob.SendString(alice.Address(), "hello", ""); err != nil {
+		t.Errorf("Expected bob->alice to still work, got %v", err)
+	}
+	network.HealLink(alice.Address(), bob.Address())
+	if err := alice.SendString(bob.Address(), "hello", ""); err != nil {
+		t.Errorf("Expected alice->bob to work after heal, got %v", err)
+	}
+
+	network.SchedulePartition([]Address{alice.Address()}, []Address{bob.Address()}, 20*time.Millisecond, 60*time.Millisecond)
+	if err := alice.SendString(bob.Address(), "hello", ""); err != nil {
+		t.Errorf("Expected alice->bob to work before the partition, got %v", err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if err := bob.SendString(alice.Address(), "hello", ""); err == nil {
+		t.Error("Expected bob->alice to fail during the partition")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if err := bob.SendString(alice.Address(), "hello", ""); err != nil {
+		t.Errorf("Expected bob->alice to work after the partition, got %v", err)
+	}
+}
+
+func TestMockNetworkMailbox(t *testing.T) {
+	network := NewMockNetwork()
+	network.SetMailbox(2)
+	aliceAddr := Address{IP: "127.0.0.1", Port: 8080}
+	bobAddr := Address{IP: "127.0.0.1", Port: 8081}
+	alice := startNodes(t, network, aliceAddr)[0]
+
+	// Bob is down, so messages wait in his mailbox until he starts
+	for i := 0; i < 2; i++ {
+		if err := alice.SendString(bobAddr, "hello", "while down"); err != nil {
+			t.Fatalf("Expected message to be queued, got %v", err)
+		}
+	}
+	if err := alice.SendString(bobAddr, "hello", "overflow"); err == nil {
+		t.Error("Expected a full mailbox to reject the message")
+	}
+
+	bob, _ := NewNode(network, bobAddr)
+	defer bob.Close()
+	received := watch(bob, "hello", nil)
+	bob.Start()
+	expectMessage(t, received, time.Second, "First queued message delivered on restart")
+	expectMessage(t, received, time.Second, "Second queued message delivered on restart")
+
+	// While partitioned, messages wait until heal
+	whilePartitioned(network, []Address{aliceAddr}, []Address{bobAddr}, func() {
+		if err := alice.SendString(bobAddr, "hello", "while partitioned"); err != nil {
+			t.Fatalf("Expected message to be queued, got %v", err)
+		}
+		expectNoMessage(t, received, 50*time.Millisecond, "Nothing delivered while partitioned")
+	})
+	msg := expectMessage(t, received, time.Second, "Queued message delivered on heal")
+	if content(msg) != "while partitioned" {
+		t.Errorf("Expected 'while partitioned', got '%s'", content(msg))
+	}
+}
+
+func TestMockNetworkGeoLatency(t *testing.T) {
+	network := NewMockNetwork()
+	alice, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8080})
+	near, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8081})
+	far, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8082})
+	defer alice.Close()
+	defer near.Close()
+	defer far.Close()
+
+	network.SetGeoLatency(time.Millisecond, 0)
+	network.SetCoordinates(alice.Address(), Coordinate{X: 0, Y: 0})
+	network.SetCoordinates(near.Address(), Coordinate{X: 3, Y: 4})
+	network.SetCoordinates(far.Address(), Coordinate{X: 60, Y: 80})
+
+	received := make(chan time.Time, 1)
+	handler := func(msg Message) error {
+		received <- time.Now()
+		return nil
+	}
+	near.Handle("hello", handler)
+	far.Handle("hello", handler)
+	near.Start()
+	far.Start()
+
+	delay := func(to Address) time.Duration {
+		start := time.Now()
+		if err := alice.SendString(to, "hello", "how far?"); err != nil {
+			t.Fatalf("Failed to send: %v", err)
+		}
+		return (<-received).Sub(start)
+	}
+	if elapsed := delay(near.Address()); elapsed < 5*time.Millisecond || elapsed >= 100*time.Millisecond {
+		t.Errorf("Expected about 5ms to the near node, got %v", elapsed)
+	}
+	if elapsed := delay(far.Address()); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected at least 100ms to the far node, got %v", elapsed)
+	}
+}
+
+func TestMockNetworkRegions(t *testing.T) {
+	network := NewMockNetwork()
+	alice, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8081})
+	carol, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8082})
+	defer alice.Close()
+	defer bob.Close()
+	defer carol.Close()
+
+	network.SetRegion(alice.Address(), "eu")
+	network.SetRegion(bob.Address(), "eu")
+	network.SetRegion(carol.Address(), "us")
+	network.SetRegionLink("eu", "us", FixedLatency(0), 1)
+
+	received := make(chan string, 10)
+	handler := func(msg Message) error {
+		received <- msg.To.String()
+		return nil
+	}
+	bob.Handle("hello", handler)
+	carol.Handle("hello", handler)
+	bob.Start()
+	carol.Start()
+
+	alice.SendString(carol.Address(), "hello", "across the ocean")
+	alice.SendString(bob.Address(), "hello", "next door")
+	time.Sleep(50 * time.Millisecond)
+	if len(received) != 1 || <-received != bob.Address().String() {
+		t.Errorf("Expected only the message within the region to arrive")
+	}
+
+	// region links are directed, us -> eu keeps the defaults
+	carol.SendString(bob.Address(), "hello", "the other way")
+	time.Sleep(50 * time.Millisecond)
+	if len(received) != 1 {
+		t.Errorf("Expected the message from us to eu to arrive")
+	}
+}
+
+func TestMockNetworkBandwidth(t *testing.T) {
+	network := NewMockNetwork()
+	alice, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8080})
+	bob, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8081})
+	defer alice.Close()
+	defer bob.Close()
+
+	// 100 byte messages, 10ms each at 10kB/s
+	network.SetBandwidth(alice.Address(), 10000, 0)
+	payload := make([]byte, 100-messageOverhead-len("hello:"))
+
+	received := make(chan time.Time, 10)
+	bob.Handle("hello", func(msg Message) error {
+		received <- time.Now()
+		return nil
+	})
+	bob.Start()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		alice.Send(bob.Address(), "hello", payload)
+	}
+	if stats := network.QueueStats(alice.Address()); stats.SendQueue == 0 || stats.MaxSendQueue != 5 {
+		t.Errorf("Expected 5 messages in the send queue: %+v", stats)
+	}
+	var last time.Time
+	for i := 0; i < 5; i++ {
+		last = <-received
+	}
+	if elapsed := last.Sub(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the last message to take at least 50ms, got %v", elapsed)
+	}
+
+	network.SetQueueLimit(2)
+	for i := 0; i < 5; i++ {
+		alice.Send(bob.Address(), "hello", payload)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(received) != 2 {
+		t.Errorf("Expected only the 2 queued messages to arrive, got %d", len(received))
+	}
+	if stats := network.QueueStats(alice.Address()); stats.SendDrops != 3 || stats.SendQueue != 0 {
+		t.Errorf("Expected 3 drops and an empty send queue: %+v", stats)
+	}
+
+	// the receiver's downlink queues and drops the same way
+	for len(received) > 0 {
+		<-received
+	}
+	network.SetBandwidth(alice.Address(), 0, 0)
+	network.SetBandwidth(bob.Address(), 0, 10000)
+	for i := 0; i < 5; i++ {
+		alice.Send(bob.Address(), "hello", payload)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(received) != 2 {
+		t.Errorf("Expected only the 2 queued messages to arrive, got %d", len(received))
+	}
+	if stats := network.QueueStats(bob.Address()); stats.RecvDrops != 3 || stats.MaxRecvQueue != 2 {
+		t.Errorf("Expected 3 drops from a receive queue of 2: %+v", stats)
+	}
+}
@@ -1,12 +1,21 @@
 package gossip
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+)
 
 type Address struct {
 	IP   string
 	Port int // 1-65535
 }
 
+func (a Address) String() string {
+	return fmt.Sprintf("%s:%d", a.IP, a.Port)
+}
+
 type Network interface {
 	Listen(addr Address) (Connection, error)
 	Dial(addr Address) (Connection, error)
@@ -16,6 +25,107 @@ type Network interface {
 	Heal()
 }
 
+// SimulatedNetwork is a Network whose links a test can control, like the
+// one NewMockNetwork returns. A real network only needs to implement
+// Network. Heal also heals the links cut with CutLink
+type SimulatedNetwork interface {
+	Network
+
+	// Link failure simulation, a cut link only fails in one direction.
+	// SchedulePartition cuts all links between the groups after at and
+	// heals them after healAt, both measured from now
+	CutLink(from, to Address)
+	HealLink(from, to Address)
+	SchedulePartition(group1, group2 []Address, at, healAt time.Duration)
+
+	// Latency simulation, per-link latency overrides the default. A nil
+	// per-link latency removes the override, LinkLatency returns it
+	SetLatency(latency LatencyDistribution)
+	SetLinkLatency(from, to Address, latency LatencyDistribution)
+	LinkLatency(from, to Address) LatencyDistribution
+
+	// Geographic latency simulation, a message between two addresses that
+	// both have coordinates is delayed by perUnit per unit of distance plus
+	// up to jitter. Per-link latency overrides it, it overrides the default
+	SetCoordinates(addr Address, coord Coordinate)
+	SetGeoLatency(perUnit, jitter time.Duration)
+
+	// Region simulation, a message between two addresses with regions
+	// uses the latency and loss of the link between their regions, set for
+	// each direction, a region may link to itself. Per-link latency
+	// overrides it, it overrides geographic and default latency, and its
+	// loss replaces the global loss rate
+	SetRegion(addr Address, region string)
+	SetRegionLink(from, to string, latency LatencyDistribution, loss float64)
+
+	// Packet loss and duplication simulation, p is a probability in [0, 1]
+	SetLossRate(p float64)
+	SetDuplicateRate(p float64)
+
+	// Store-and-forward simulation, messages to an address that is down or
+	// unreachable are queued, up to capacity per address, and delivered on
+	// restart or heal. Capacity 0 turns it off and drops the queued messages
+	SetMailbox(capacity int)
+
+	// Bandwidth simulation, a node sends and receives at most send and
+	// recv bytes per second, 0 is unlimited. A message waits in the send
+	// queue of its sender and the receive queue of its receiver until it
+	// is transmitted, and is dropped if a queue already holds capacity
+	// messages, capacity 0 leaves queues unbounded. A message is its
+	// payload plus 28 bytes of headers
+	SetBandwidth(addr Address, send, recv int)
+	SetQueueLimit(capacity int)
+	QueueStats(addr Address) QueueStats
+
+	// Deterministic simulation, latency, loss and duplication are drawn
+	// from seed, so the same seed delays and drops the same messages
+	SetSeed(seed int64)
+}
+
+// LatencyDistribution returns the delay for a single message,
+// drawing any randomness from rng
+type LatencyDistribution func(rng *Random) time.Duration
+
+// FixedLatency delays every message by d
+func FixedLatency(d time.Duration) LatencyDistribution {
+	return func(rng *Random) time.Duration {
+		return d
+	}
+}
+
+// UniformLatency delays messages uniformly between min and max,
+// the bounds may be given in either order
+func UniformLatency(min, max time.Duration) LatencyDistribution {
+	if max < min {
+		min, max = max, min
+	}
+	return func(rng *Random) time.Duration {
+		return min + time.Duration(rng.Int63n(int64(max-min)+1))
+	}
+}
+
+// NormalLatency delays messages normally distributed around mean,
+// never less than zero
+func NormalLatency(mean, stddev time.Duration) LatencyDistribution {
+	return func(rng *Random) time.Duration {
+		d := mean + time.Duration(rng.NormFloat64()*float64(stddev))
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// Coordinate is the virtual position of a node on a plane
+type Coordinate struct {
+	X, Y float64
+}
+
+// Distance returns the euclidean distance between c and other
+func (c Coordinate) Distance(other Coordinate) float64 {
+	return math.Hypot(c.X-other.X, c.Y-other.Y)
+}
+
 type Connection interface {
 	Send(msg Message) error
 	Recv() (Message, error)
@@ -23,10 +133,29 @@ type Connection interface {
 }
 
 type Message struct {
-	From    Address
-	To      Address
-	Payload []byte
-	network Network // Reference to network for replies
+	From      Address
+	To        Address
+	Payload   []byte
+	ID        string  // correlation id, set on requests made with Node.Request
+	InReplyTo string  // id of the request this message replies to
+	network   Network // Reference to network for replies
+}
+
+// Type returns the message type, the part of the payload before the
+// first ':', or "default" for a payload without type
+func (m Message) Type() string {
+	if i := bytes.IndexByte(m.Payload, ':'); i >= 0 {
+		return string(m.Payload[:i])
+	}
+	return "default"
+}
+
+// Body returns the payload without its "msgType:" prefix
+func (m Message) Body() []byte {
+	if i := bytes.IndexByte(m.Payload, ':'); i >= 0 {
+		return m.Payload[i+1:]
+	}
+	return m.Payload
 }
 
 // Reply sends a response message back to the sender
@@ -48,10 +177,11 @@ func (m Message) Reply(msgType string, data []byte) error {
 
 	// Create reply message
 	reply := Message{
-		From:    m.To,
-		To:      m.From,
-		Payload: payload,
-		network: m.network,
+		From:      m.To,
+		To:        m.From,
+		Payload:   payload,
+		InReplyTo: m.ID,
+		network:   m.network,
 	}
 
 	return connection.Send(reply)
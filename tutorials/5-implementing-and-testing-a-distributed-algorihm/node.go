@@ -1,9 +1,11 @@
 package gossip
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sync"
+	"time"
 )
 
 // Node provides a unified abstraction for both sending and receiving messages
@@ -12,9 +14,16 @@ type Node struct {
 	network    Network
 	connection Connection
 	handlers   map[string]MessageHandler
+	pending    map[string]chan Message // requests waiting for a reply
+	nextID     uint64
 	mu         sync.RWMutex
 	closed     bool
 	closeMu    sync.RWMutex
+
+	// graceful close tracking
+	inflight  sync.WaitGroup // running handlers and sends
+	stopped   chan struct{}  // closed when the receive loop exits
+	discarded int            // messages received after close, not handled
 }
 
 // MessageHandler is a function that processes incoming messages
@@ -32,6 +41,7 @@ func NewNode(network Network, addr Address) (*Node, error) {
 		network:    network,
 		connection: connection,
 		handlers:   make(map[string]MessageHandler),
+		pending:    make(map[string]chan Message),
 	}, nil
 }
 
@@ -44,15 +54,14 @@ func (n *Node) Handle(msgType string, handler MessageHandler) {
 
 // Start begins listening for incoming messages
 func (n *Node) Start() {
+	stopped := make(chan struct{})
+	n.closeMu.Lock()
+	n.stopped = stopped
+	n.closeMu.Unlock()
+
 	go func() {
+		defer close(stopped)
 		for {
-			n.closeMu.RLock()
-			if n.closed {
-				n.closeMu.RUnlock()
-				return
-			}
-			n.closeMu.RUnlock()
-
 			msg, err := n.connection.Recv()
 			if err != nil {
 				n.closeMu.RLock()
@@ -63,15 +72,34 @@ func (n *Node) Start() {
 				return
 			}
 
-			// Extract message type from payload (first part before ':')
-			msgType := "default"
-			payload := string(msg.Payload)
-			if len(payload) > 0 {
-				for i, char := range payload {
-					if char == ':' {
-						msgType = payload[:i]
-						break
+			// Messages still queued once the node is closed are discarded
+			n.closeMu.RLock()
+			if n.closed {
+				n.closeMu.RUnlock()
+				n.mu.Lock()
+				n.discarded++
+				n.mu.Unlock()
+				continue
+			}
+			n.inflight.Add(1)
+			n.closeMu.RUnlock()
+
+			msgType := msg.Type()
+
+			// Replies to a pending Request go to the waiting caller,
+			// late replies fall through to the handlers
+			if msg.InReplyTo != "" {
+				n.mu.RLock()
+				replyCh, waiting := n.pending[msg.InReplyTo]
+				n.mu.RUnlock()
+				if waiting {
+					// Only the first reply counts, duplicates are dropped
+					select {
+					case replyCh <- msg:
+					default:
 					}
+					n.inflight.Done()
+					continue
 				}
 			}
 
@@ -87,12 +115,55 @@ func (n *Node) Start() {
 					log.Printf("Handler error: %v", err)
 				}
 			}
+			n.inflight.Done()
 		}
 	}()
 }
 
 // Send sends a message to the target address
 func (n *Node) Send(to Address, msgType string, data []byte) error {
+	return n.send(to, msgType, data, "")
+}
+
+// Request sends a message to the target address and waits for the
+// reply the receiver sends with msg.Reply, or until ctx is done
+func (n *Node) Request(ctx context.Context, to Address, msgType string, data []byte) (Message, error) {
+	replyCh := make(chan Message, 1)
+	n.mu.Lock()
+	n.nextID++
+	id := fmt.Sprintf("%s/%d", n.addr.String(), n.nextID)
+	n.pending[id] = replyCh
+	n.mu.Unlock()
+
+	defer func() {
+		n.mu.Lock()
+		delete(n.pending, id)
+		n.mu.Unlock()
+	}()
+
+	if err := n.send(to, msgType, data, id); err != nil {
+		return Message{}, err
+	}
+
+	select {
+	case reply := <-replyCh:
+		return reply, nil
+	case <-ctx.Done():
+		return Message{}, fmt.Errorf("no reply from %s: %v", to.String(), ctx.Err())
+	}
+}
+
+// send sends a message with the given correlation id to the target address
+func (n *Node) send(to Address, msgType string, data []byte, id string) error {
+	// Sends started before a graceful close are waited for, later
+	// ones come from handlers that are being waited for already
+	n.closeMu.RLock()
+	if !n.closed {
+		n.inflight.Add(1)
+		defer n.inflight.Done()
+	}
+	n.closeMu.RUnlock()
+
 	connection, err := n.network.Dial(to)
 	if err != nil {
 		return fmt.Errorf("failed to dial %s: %v", to.String(), err)
@@ -111,6 +182,7 @@ func (n *Node) Send(to Address, msgType string, data []byte) error {
 		From:    n.addr,
 		To:      to,
 		Payload: payload,
+		ID:      id,
 	}
 
 	return connection.Send(msg)
@@ -129,6 +201,46 @@ func (n *Node) Close() error {
 	return n.connection.Close()
 }
 
+// CloseGracefully shuts down the node like Close, but first waits up to
+// timeout for running handlers and sends to finish. It returns how many
+// received messages were discarded without being handled
+func (n *Node) CloseGracefully(timeout time.Duration) (int, error) {
+	n.closeMu.Lock()
+	n.closed = true
+	stopped := n.stopped
+	n.closeMu.Unlock()
+
+	deadline := time.After(timeout)
+	finished := make(chan struct{})
+	go func() {
+		n.inflight.Wait()
+		close(finished)
+	}()
+
+	var err error
+	select {
+	case <-finished:
+	case <-deadline:
+		err = fmt.Errorf("node %s timed out waiting for handlers and sends", n.addr.String())
+	}
+
+	if closeErr := n.connection.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+
+	// Let the receive loop count the messages left in the queue
+	if stopped != nil {
+		select {
+		case <-stopped:
+		case <-deadline:
+		}
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.discarded, err
+}
+
 // Address returns the node's address
 func (n *Node) Address() Address {
 	return n.addr
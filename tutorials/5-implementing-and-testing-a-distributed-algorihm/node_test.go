@@ -7,10 +7,6 @@ import (
 	"time"
 )
 
-func (a Address) String() string {
-	return fmt.Sprintf("%s:%d", a.IP, a.Port)
-}
-
 func TestHelloworld(t *testing.T) {
 	// Create network and nodes
 	network := NewMockNetwork()
@@ -46,382 +42,190 @@ func TestHelloworld(t *testing.T) {
 }
 
 func TestNodeCommunication(t *testing.T) {
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Create a mock network
 	network := NewMockNetwork()
-
-	// Define addresses
 	nodeAAddr := Address{IP: "127.0.0.1", Port: 8080}
 	nodeBAddr := Address{IP: "127.0.0.1", Port: 8081}
+	nodes := startNodes(t, network, nodeAAddr, nodeBAddr)
+	nodeA, nodeB := nodes[0], nodes[1]
 
-	// Create nodes
-	nodeA, err := NewNode(network, nodeAAddr)
-	if err != nil {
-		t.Fatalf("Failed to create node A: %v", err)
-	}
-
-	nodeB, err := NewNode(network, nodeBAddr)
-	if err != nil {
-		t.Fatalf("Failed to create node B: %v", err)
-	}
-
-	// Channels for synchronization
-	nodeAReceived := make(chan struct{}, 2)
-	nodeBReceived := make(chan struct{}, 2)
-
-	// Helper function to extract message content after ":"
-	extractContent := func(payload []byte) string {
-		payloadStr := string(payload)
-		for i, char := range payloadStr {
-			if char == ':' {
-				return payloadStr[i+1:]
-			}
-		}
-		return payloadStr
-	}
-
-	// Helper function to wait for channel with timeout
-	waitForSignal := func(ch <-chan struct{}, description string) {
-		select {
-		case <-ch:
-			t.Logf("✓ %s", description)
-		case <-ctx.Done():
-			t.Fatalf("Timeout waiting for: %s", description)
-		}
-	}
-
-	// Register message handlers for node A
-	nodeA.Handle("ping", func(msg Message) error {
-		content := extractContent(msg.Payload)
-		t.Logf("Node A received ping from %s: %s", msg.From.String(), content)
-		nodeAReceived <- struct{}{}
-
-		// Send pong back
+	// Each node answers a ping with a pong
+	pingsA := watch(nodeA, "ping", func(msg Message) error {
+		t.Logf("Node A received ping from %s: %s", msg.From.String(), content(msg))
 		return nodeA.SendString(msg.From, "pong", "pong response from A")
 	})
-
-	nodeA.Handle("pong", func(msg Message) error {
-		content := extractContent(msg.Payload)
-		t.Logf("Node A received pong from %s: %s", msg.From.String(), content)
-		nodeAReceived <- struct{}{}
-		return nil
-	})
-
-	// Register message handlers for node B
-	nodeB.Handle("ping", func(msg Message) error {
-		content := extractContent(msg.Payload)
-		t.Logf("Node B received ping from %s: %s", msg.From.String(), content)
-		nodeBReceived <- struct{}{}
-
-		// Send pong back
+	pongsA := watch(nodeA, "pong", nil)
+	pingsB := watch(nodeB, "ping", func(msg Message) error {
+		t.Logf("Node B received ping from %s: %s", msg.From.String(), content(msg))
 		return nodeB.SendString(msg.From, "pong", "pong response from B")
 	})
+	pongsB := watch(nodeB, "pong", nil)
 
-	nodeB.Handle("pong", func(msg Message) error {
-		content := extractContent(msg.Payload)
-		t.Logf("Node B received pong from %s: %s", msg.From.String(), content)
-		nodeBReceived <- struct{}{}
-		return nil
-	})
-
-	// Start both nodes
-	nodeA.Start()
-	nodeB.Start()
-	t.Logf("Node A started on %s", nodeAAddr.String())
-	t.Logf("Node B started on %s", nodeBAddr.String())
-
-	// Send some messages
 	t.Log("=== Sending messages ===")
-
-	// Node A sends ping to Node B
-	err = nodeA.SendString(nodeBAddr, "ping", "Hello from A!")
-	if err != nil {
+	if err := nodeA.SendString(nodeBAddr, "ping", "Hello from A!"); err != nil {
 		t.Errorf("Failed to send ping: %v", err)
 	}
-
-	// Node B sends ping to Node A
-	err = nodeB.SendString(nodeAAddr, "ping", "Hello from B!")
-	if err != nil {
+	if err := nodeB.SendString(nodeAAddr, "ping", "Hello from B!"); err != nil {
 		t.Errorf("Failed to send ping: %v", err)
 	}
 
-	// Wait for both ping messages to be received and pong responses with timeout
-	waitForSignal(nodeBReceived, "Node B receives ping from A")
-	waitForSignal(nodeAReceived, "Node A receives ping from B")
-	waitForSignal(nodeAReceived, "Node A receives pong from B")
-	waitForSignal(nodeBReceived, "Node B receives pong from A")
-
-	// Clean up
-	nodeA.Close()
-	nodeB.Close()
-
-	t.Log("Node communication test completed successfully")
+	expectMessage(t, pingsB, 5*time.Second, "Node B receives ping from A")
+	expectMessage(t, pingsA, 5*time.Second, "Node A receives ping from B")
+	expectMessage(t, pongsA, 5*time.Second, "Node A receives pong from B")
+	expectMessage(t, pongsB, 5*time.Second, "Node B receives pong from A")
 }
 
 func TestNetworkPartitioning(t *testing.T) {
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Create a mock network
 	network := NewMockNetwork()
-
-	// Define addresses
 	nodeAAddr := Address{IP: "127.0.0.1", Port: 8080}
 	nodeBAddr := Address{IP: "127.0.0.1", Port: 8081}
+	nodes := startNodes(t, network, nodeAAddr, nodeBAddr)
+	nodeA, nodeB := nodes[0], nodes[1]
 
-	// Create nodes
-	nodeA, err := NewNode(network, nodeAAddr)
-	if err != nil {
-		t.Fatalf("Failed to create node A: %v", err)
-	}
-
-	nodeB, err := NewNode(network, nodeBAddr)
-	if err != nil {
-		t.Fatalf("Failed to create node B: %v", err)
-	}
-
-	// Channel for synchronization
-	messageReceived := make(chan struct{}, 2)
-
-	// Helper function to extract message content after ":"
-	extractContent := func(payload []byte) string {
-		payloadStr := string(payload)
-		for i, char := range payloadStr {
-			if char == ':' {
-				return payloadStr[i+1:]
-			}
-		}
-		return payloadStr
-	}
+	received := watch(nodeB, "test", nil)
 
-	// Helper function to wait for channel with timeout
-	waitForMessage := func(description string) {
-		select {
-		case <-messageReceived:
-			t.Logf("✓ %s", description)
-		case <-ctx.Done():
-			t.Fatalf("Timeout waiting for: %s", description)
-		}
-	}
-
-	// Register message handler for node B
-	nodeB.Handle("test", func(msg Message) error {
-		content := extractContent(msg.Payload)
-		t.Logf("Node B received message: %s", content)
-		messageReceived <- struct{}{}
-		return nil
-	})
-
-	// Start nodes
-	nodeA.Start()
-	nodeB.Start()
-
-	// Test normal communication first
 	t.Log("=== Testing normal communication ===")
-	err = nodeA.SendString(nodeBAddr, "test", "Normal message")
-	if err != nil {
+	if err := nodeA.SendString(nodeBAddr, "test", "Normal message"); err != nil {
 		t.Errorf("Failed to send normal message: %v", err)
 	}
+	expectMessage(t, received, 5*time.Second, "Normal message received")
 
-	// Wait for message to be received with timeout
-	waitForMessage("Normal message received")
-
-	// Test network partitioning
 	t.Log("=== Testing network partition ===")
-	network.Partition([]Address{nodeAAddr}, []Address{nodeBAddr})
-
-	err = nodeA.SendString(nodeBAddr, "test", "This should fail")
-	if err == nil {
-		t.Error("Expected partition error, but message was sent successfully")
-	} else {
-		t.Logf("✓ Expected partition error: %v", err)
-	}
+	whilePartitioned(network, []Address{nodeAAddr}, []Address{nodeBAddr}, func() {
+		if err := nodeA.SendString(nodeBAddr, "test", "This should fail"); err == nil {
+			t.Error("Expected partition error, but message was sent successfully")
+		} else {
+			t.Logf("✓ Expected partition error: %v", err)
+		}
+		expectNoMessage(t, received, 50*time.Millisecond, "No message received while partitioned")
+	})
 
-	// Heal the network
 	t.Log("=== Testing network heal ===")
-	network.Heal()
-
-	err = nodeA.SendString(nodeBAddr, "test", "This should work again")
-	if err != nil {
+	if err := nodeA.SendString(nodeBAddr, "test", "This should work again"); err != nil {
 		t.Errorf("Unexpected error after heal: %v", err)
 	}
-
-	// Wait for final message to be received with timeout
-	waitForMessage("Message after heal received")
-
-	// Clean up
-	nodeA.Close()
-	nodeB.Close()
-
-	t.Log("Network partitioning test completed successfully")
+	expectMessage(t, received, 5*time.Second, "Message after heal received")
 }
 
 func TestBroadcastPattern(t *testing.T) {
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Create a mock network
 	network := NewMockNetwork()
-
-	// Create multiple nodes
-	nodes := make([]*Node, 3)
 	addrs := []Address{
 		{IP: "127.0.0.1", Port: 8080},
 		{IP: "127.0.0.1", Port: 8081},
 		{IP: "127.0.0.1", Port: 8082},
 	}
+	nodes := startNodes(t, network, addrs...)
 
-	// Channel to track received messages
-	received := make(chan string, 10)
-
-	// Helper function to extract message content after ":"
-	extractContent := func(payload []byte) string {
-		payloadStr := string(payload)
-		for i, char := range payloadStr {
-			if char == ':' {
-				return payloadStr[i+1:]
-			}
-		}
-		return payloadStr
-	}
-
-	// Helper function to wait for broadcast message with timeout
-	waitForBroadcast := func(expectedCount int) {
-		for i := 0; i < expectedCount; i++ {
-			select {
-			case msg := <-received:
-				t.Logf("✓ %s", msg)
-			case <-ctx.Done():
-				t.Fatalf("Timeout waiting for broadcast message %d/%d", i+1, expectedCount)
-			}
-		}
-	}
-
-	// Create and start nodes
-	for i, addr := range addrs {
-		node, err := NewNode(network, addr)
-		if err != nil {
-			t.Fatalf("Failed to create node %d: %v", i, err)
-		}
-		nodes[i] = node
-
-		// Register broadcast handler
-		node.Handle("broadcast", func(msg Message) error {
-			content := extractContent(msg.Payload)
-			received <- fmt.Sprintf("Node %s received: %s", msg.To.String(), content)
-			return nil
-		})
-
-		node.Start()
+	inboxes := make([]inbox, len(nodes))
+	for i, node := range nodes {
+		inboxes[i] = watch(node, "broadcast", nil)
 	}
 
 	// Node 0 broadcasts to all other nodes
 	t.Log("=== Testing broadcast pattern ===")
 	for i := 1; i < len(addrs); i++ {
-		err := nodes[0].SendString(addrs[i], "broadcast", "Broadcast message")
-		if err != nil {
+		if err := nodes[0].SendString(addrs[i], "broadcast", "Broadcast message"); err != nil {
 			t.Errorf("Failed to send broadcast to node %d: %v", i, err)
 		}
 	}
 
-	// Wait for all messages to be received with timeout
-	expectedMessages := len(addrs) - 1 // All nodes except sender
-	waitForBroadcast(expectedMessages)
-
-	// Clean up
-	for _, node := range nodes {
-		node.Close()
+	for i := 1; i < len(addrs); i++ {
+		msg := expectMessage(t, inboxes[i], 5*time.Second, fmt.Sprintf("Node %s receives broadcast", addrs[i].String()))
+		if content(msg) != "Broadcast message" {
+			t.Errorf("Expected 'Broadcast message', got '%s'", content(msg))
+		}
 	}
-
-	t.Log("Broadcast pattern test completed successfully")
+	expectNoMessage(t, inboxes[0], 50*time.Millisecond, "Sender does not receive its own broadcast")
 }
 
 func TestRequestResponsePattern(t *testing.T) {
-	// Create context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// Create a mock network
 	network := NewMockNetwork()
-
-	// Define addresses
 	clientAddr := Address{IP: "127.0.0.1", Port: 8080}
 	serverAddr := Address{IP: "127.0.0.1", Port: 8081}
+	nodes := startNodes(t, network, clientAddr, serverAddr)
+	client, server := nodes[0], nodes[1]
 
-	// Create nodes
-	client, err := NewNode(network, clientAddr)
-	if err != nil {
-		t.Fatalf("Failed to create client: %v", err)
+	// Server handles requests, client collects responses
+	watch(server, "request", func(msg Message) error {
+		t.Logf("Server received request: %s", content(msg))
+		return server.SendString(msg.From, "response", "Hello from server")
+	})
+	responses := watch(client, "response", nil)
+
+	t.Log("=== Testing request-response pattern ===")
+	if err := client.SendString(serverAddr, "request", "Hello from client"); err != nil {
+		t.Errorf("Failed to send request: %v", err)
 	}
 
-	server, err := NewNode(network, serverAddr)
-	if err != nil {
-		t.Fatalf("Failed to create server: %v", err)
+	response := content(expectMessage(t, responses, 5*time.Second, "Response received"))
+	expected := "Hello from server"
+	if response != expected {
+		t.Errorf("Expected response '%s', got '%s'", expected, response)
 	}
+}
 
-	// Channel for response synchronization
-	responseReceived := make(chan string, 1)
+func TestGracefulClose(t *testing.T) {
+	network := NewMockNetwork()
+	sender, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8080})
+	receiver, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8081})
+	defer sender.Close()
+
+	// The first message blocks the handler while two more are queued
+	started := make(chan struct{}, 3)
+	handled := 0
+	receiver.Handle("work", func(msg Message) error {
+		started <- struct{}{}
+		time.Sleep(50 * time.Millisecond)
+		handled++
+		return nil
+	})
+	receiver.Start()
 
-	// Helper function to extract message content after ":"
-	extractContent := func(payload []byte) string {
-		payloadStr := string(payload)
-		for i, char := range payloadStr {
-			if char == ':' {
-				return payloadStr[i+1:]
-			}
+	for i := 0; i < 3; i++ {
+		if err := sender.SendString(receiver.Address(), "work", "job"); err != nil {
+			t.Fatalf("Failed to send: %v", err)
 		}
-		return payloadStr
 	}
+	<-started
 
-	// Server handles requests
-	server.Handle("request", func(msg Message) error {
-		content := extractContent(msg.Payload)
-		t.Logf("Server received request: %s", content)
-		// Send response back
-		return server.SendString(msg.From, "response", "Hello from server")
-	})
+	discarded, err := receiver.CloseGracefully(time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error closing gracefully: %v", err)
+	}
+	if handled != 1 {
+		t.Errorf("Expected the running handler to finish, got %d handled", handled)
+	}
+	if discarded != 2 {
+		t.Errorf("Expected 2 discarded messages, got %d", discarded)
+	}
+}
 
-	// Client handles responses
-	client.Handle("response", func(msg Message) error {
-		content := extractContent(msg.Payload)
-		t.Logf("Client received response: %s", content)
-		responseReceived <- content
-		return nil
-	})
+func TestRequestHelper(t *testing.T) {
+	network := NewMockNetwork()
+	client, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8080})
+	server, _ := NewNode(network, Address{IP: "127.0.0.1", Port: 8081})
+	defer client.Close()
+	defer server.Close()
 
-	// Start nodes
+	server.Handle("echo", func(msg Message) error {
+		return msg.Reply("echo", msg.Body())
+	})
 	client.Start()
 	server.Start()
 
-	// Send request
-	t.Log("=== Testing request-response pattern ===")
-	err = client.SendString(serverAddr, "request", "Hello from client")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := client.Request(ctx, server.Address(), "echo", []byte("hello"))
 	if err != nil {
-		t.Errorf("Failed to send request: %v", err)
+		t.Fatalf("Request failed: %v", err)
 	}
-
-	// Wait for response with timeout
-	var response string
-	select {
-	case response = <-responseReceived:
-		t.Logf("✓ Response received: %s", response)
-	case <-ctx.Done():
-		t.Fatal("Timeout waiting for response")
+	if string(reply.Payload) != "echo:hello" {
+		t.Errorf("Expected reply 'echo:hello', got '%s'", reply.Payload)
 	}
 
-	expected := "Hello from server"
-	if response != expected {
-		t.Errorf("Expected response '%s', got '%s'", expected, response)
+	// Nobody replies to "ignored", so the request times out
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Request(ctx, server.Address(), "ignored", nil); err == nil {
+		t.Error("Expected request without reply to time out")
 	}
-
-	// Clean up
-	client.Close()
-	server.Close()
-
-	t.Log("Request-response pattern test completed successfully")
 }
@@ -0,0 +1,67 @@
+package gossip
+
+import (
+	mathrand "math/rand"
+	"sync"
+	"time"
+)
+
+// Random is a seeded source of randomness that is safe to share between
+// goroutines. Everything random in a simulation draws from one, so a run
+// makes the same random choices again when it is given the same seed
+type Random struct {
+	mu  sync.Mutex
+	rng *mathrand.Rand
+}
+
+// NewRandom returns a Random seeded with seed
+func NewRandom(seed int64) *Random {
+	return &Random{rng: mathrand.New(mathrand.NewSource(seed))}
+}
+
+// newSeed returns a seed for runs that were not given one
+func newSeed() int64 {
+	return time.Now().UnixNano()
+}
+
+func (r *Random) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Intn(n)
+}
+
+func (r *Random) Int63() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Int63()
+}
+
+func (r *Random) Int63n(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Int63n(n)
+}
+
+func (r *Random) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Float64()
+}
+
+func (r *Random) NormFloat64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.NormFloat64()
+}
+
+func (r *Random) Shuffle(n int, swap func(i, j int)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rng.Shuffle(n, swap)
+}
+
+func (r *Random) Read(p []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rng.Read(p)
+}
@@ -0,0 +1,107 @@
+package gossip
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Scenario helpers shared by the tests, they replace the channel and
+// context boilerplate otherwise needed to wait for messages
+
+// simulationSeed returns the seed for a simulated run, GOSSIP_SEED if it
+// is set, and logs it when the test fails so that the run can be replayed
+// with GOSSIP_SEED=<seed> go test -run <test>
+func simulationSeed(t *testing.T) int64 {
+	t.Helper()
+	seed := time.Now().UnixNano()
+	if env := os.Getenv("GOSSIP_SEED"); env != "" {
+		parsed, err := strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			t.Fatalf("Invalid GOSSIP_SEED %q: %v", env, err)
+		}
+		seed = parsed
+	}
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("replay with GOSSIP_SEED=%d", seed)
+		}
+	})
+	return seed
+}
+
+// inbox collects the messages of one type delivered to a node
+type inbox chan Message
+
+// watch registers a handler for msgType on node that records every
+// message in the returned inbox, and then calls respond unless it is nil
+func watch(node *Node, msgType string, respond MessageHandler) inbox {
+	in := make(inbox, 100)
+	node.Handle(msgType, func(msg Message) error {
+		in <- msg
+		if respond != nil {
+			return respond(msg)
+		}
+		return nil
+	})
+	return in
+}
+
+// expectMessage fails the test unless a message arrives in in within
+// the given time, and returns the message
+func expectMessage(t *testing.T, in inbox, within time.Duration, description string) Message {
+	t.Helper()
+	select {
+	case msg := <-in:
+		t.Logf("✓ %s", description)
+		return msg
+	case <-time.After(within):
+		t.Fatalf("Timeout waiting for: %s", description)
+		return Message{}
+	}
+}
+
+// expectNoMessage fails the test if a message arrives in in within
+// the given time
+func expectNoMessage(t *testing.T, in inbox, within time.Duration, description string) {
+	t.Helper()
+	select {
+	case msg := <-in:
+		t.Fatalf("Unexpected message from %s: %s", msg.From.String(), description)
+	case <-time.After(within):
+		t.Logf("✓ %s", description)
+	}
+}
+
+// content returns the payload of msg after the "type:" prefix
+func content(msg Message) string {
+	return string(msg.Body())
+}
+
+// whilePartitioned runs step with group1 and group2 partitioned
+// and heals the network afterwards
+func whilePartitioned(network Network, group1, group2 []Address, step func()) {
+	network.Partition(group1, group2)
+	defer network.Heal()
+	step()
+}
+
+// startNodes creates and starts a node for every address, closing
+// them when the test ends
+func startNodes(t *testing.T, network Network, addrs ...Address) []*Node {
+	t.Helper()
+	nodes := make([]*Node, len(addrs))
+	for i, addr := range addrs {
+		node, err := NewNode(network, addr)
+		if err != nil {
+			t.Fatalf("Failed to create node %s: %v", addr.String(), err)
+		}
+		t.Cleanup(func() { node.Close() })
+		nodes[i] = node
+	}
+	for _, node := range nodes {
+		node.Start()
+	}
+	return nodes
+}
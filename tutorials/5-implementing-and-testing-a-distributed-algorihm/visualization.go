@@ -1,11 +1,12 @@
 package gossip
 
 import (
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"math"
-	mathrand "math/rand"
 	"os"
+	"text/template"
 	"time"
 )
 
@@ -33,6 +34,7 @@ type NodeInfo struct {
 	X         int    `json:"x"`
 	Y         int    `json:"y"`
 	ClusterID int    `json:"clusterId"`
+	Region    string `json:"region,omitempty"`
 }
 
 // EdgeInfo represents a connection between nodes
@@ -73,15 +75,7 @@ func (nb *NetworkBuilder) ExportVisualizationData(outputDir string) error {
 		return fmt.Errorf("failed to create output directory: %v", err)
 	}
 
-	// Generate network topology
-	topology := nb.generateTopology()
-
-	// Create visualization data
-	visData := VisualizationData{
-		Topology:  topology,
-		Traces:    nb.traces,
-		StartTime: nb.startTime,
-	}
+	visData := nb.visualizationData()
 
 	// Write to JSON file
 	data, err := json.MarshalIndent(visData, "", "  ")
@@ -96,10 +90,63 @@ func (nb *NetworkBuilder) ExportVisualizationData(outputDir string) error {
 	}
 
 	fmt.Printf("Exported visualization data to %s\n", filename)
-	fmt.Printf("Total nodes: %d, Total message traces: %d\n", len(topology.Nodes), len(nb.traces))
+	fmt.Printf("Total nodes: %d, Total message traces: %d\n", len(visData.Topology.Nodes), len(visData.Traces))
 	return nil
 }
 
+// visualizationPage is the page written by ExportHTML, the
+// visualization data is embedded where the template says {{.}}
+//
+//go:embed visualization.html
+var visualizationPage string
+
+// ExportHTML writes the same data as ExportVisualizationData into a
+// self-contained HTML page that animates how messages spread over time,
+// so results can be opened in a browser without the React visualizer
+func (nb *NetworkBuilder) ExportHTML(outputDir string) error {
+	err := os.MkdirAll(outputDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+
+	// json.Marshal escapes <, > and &, so the data can't end the script tag
+	data, err := json.Marshal(nb.visualizationData())
+	if err != nil {
+		return fmt.Errorf("failed to marshal visualization data: %v", err)
+	}
+	page, err := template.New("visualization").Parse(visualizationPage)
+	if err != nil {
+		return fmt.Errorf("failed to parse visualization page: %v", err)
+	}
+
+	filename := fmt.Sprintf("%s/network_visualization.html", outputDir)
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create visualization page: %v", err)
+	}
+	defer file.Close()
+	if err := page.Execute(file, string(data)); err != nil {
+		return fmt.Errorf("failed to write visualization page: %v", err)
+	}
+
+	fmt.Printf("Exported visualization page to %s\n", filename)
+	return nil
+}
+
+// visualizationData collects the topology and the traces logged so far
+func (nb *NetworkBuilder) visualizationData() VisualizationData {
+	nb.traceMu.Lock()
+	traces := make([]MessageTrace, len(nb.traces))
+	copy(traces, nb.traces)
+	nb.traceMu.Unlock()
+
+	return VisualizationData{
+		Topology:  nb.generateTopology(),
+		Traces:    traces,
+		StartTime: nb.startTime,
+	}
+}
+
 // generateTopology creates the network topology for visualization
 func (nb *NetworkBuilder) generateTopology() NetworkTopology {
 	nodes := make([]NodeInfo, len(nb.nodes))
@@ -159,6 +206,7 @@ func (nb *NetworkBuilder) generateTopology() NetworkTopology {
 			X:         int(pos.X),
 			Y:         int(pos.Y),
 			ClusterID: clusterID,
+			Region:    nb.regions[node.GetID()],
 		}
 	}
 
@@ -257,8 +305,8 @@ func (nb *NetworkBuilder) forceDirectedLayoutForCluster(cluster []int, connectio
 	// Initialize random positions within bounds
 	for _, nodeID := range cluster {
 		positions[nodeID] = Position{
-			X: mathrand.Float64() * float64(width),
-			Y: mathrand.Float64() * float64(height),
+			X: nb.rng.Float64() * float64(width),
+			Y: nb.rng.Float64() * float64(height),
 		}
 		velocities[nodeID] = Position{X: 0, Y: 0}
 	}
@@ -379,8 +427,8 @@ func (nb *NetworkBuilder) simulateForceDirectedLayout(connections map[int][]int,
 	// Initialize random positions
 	for _, node := range nb.nodes {
 		positions[node.GetID()] = Position{
-			X: mathrand.Float64() * width,
-			Y: mathrand.Float64() * height,
+			X: nb.rng.Float64() * width,
+			Y: nb.rng.Float64() * height,
 		}
 		velocities[node.GetID()] = Position{X: 0, Y: 0}
 	}